@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+)
+
+// assign replaces the value of target with the result of expr, both taken
+// from the environment the debugger is stopped in. target is a variable, or
+// a field or element of a value reachable from a variable, self or $.
+//
+// Variables and array elements are thunks, they are rebound in place so
+// every value referencing them sees the new value, including closures that
+// captured them. Fields are overridden in the cache of their object. Values
+// that were already computed from the old value are not updated.
+//
+// expr is evaluated like the program would, so the parts of the new value
+// that are not needed yet, like fields of objects, are computed later in
+// the scope of expr.
+func assign(dbg *jsonnet.Debugger, target, expr string) error {
+	node, _, err := formatter.SnippetToRawAST("<expression>", target)
+	if err != nil {
+		return err
+	}
+	v, err := evaluateValue(dbg, expr)
+	if err != nil {
+		return err
+	}
+	switch n := node.(type) {
+	case *ast.Var:
+		thunk, err := variableThunk(dbg, string(n.Id))
+		if err != nil {
+			return err
+		}
+		if !thunk.IsValid() {
+			return fmt.Errorf("Unknown variable: %s", n.Id)
+		}
+		return setThunkValue(thunk, v)
+	case *ast.Index:
+		container, err := runtimeValue(dbg, n.Target)
+		if err != nil {
+			return err
+		}
+		if !container.IsValid() {
+			return fmt.Errorf("Only values that have been evaluated can be changed")
+		}
+		if n.Id != nil {
+			return assignField(container, string(*n.Id), v)
+		}
+		switch index := n.Index.(type) {
+		case *ast.LiteralString:
+			return assignField(container, index.Value, v)
+		case *ast.LiteralNumber:
+			i, err := strconv.Atoi(index.OriginalString)
+			if err != nil || !isValueType(container, "valueArray") {
+				return fmt.Errorf("Only arrays can be indexed with numbers")
+			}
+			thunk, err := arrayThunk(container, i)
+			if err != nil {
+				return err
+			}
+			if !thunk.IsValid() {
+				return fmt.Errorf("Index %d out of bounds", i)
+			}
+			return setThunkValue(thunk, v)
+		}
+	}
+	return fmt.Errorf("Only variables, object fields and array elements can be set")
+}
+
+// assignField overrides the field name of the object obj with v.
+func assignField(obj reflect.Value, name string, v reflect.Value) error {
+	if !isValueType(obj, "valueObject") {
+		return fmt.Errorf("Only objects have fields")
+	}
+	return setObjectField(obj, name, v)
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestAssign(t *testing.T) {
+	src := `local f(n) =
+  local obj = { a: 1, b: 2 };
+  local arr = [1, 2];
+  [n, obj.a, obj.b, arr[1]];
+f(1)
+`
+	dbg := stopAt(t, src, 4, 3)
+	for _, tc := range []struct{ target, expr string }{
+		{"n", "local g(x) = x * 10; g(obj.b)"},
+		{"obj", "obj + { b: 3 }"},
+		{"obj.a", "{ x: self.y, y: n }.x"},
+		{"arr[1]", "std.length(arr) * 100"},
+	} {
+		if err := assign(dbg, tc.target, tc.expr); err != nil {
+			t.Errorf("%s = %s: %v", tc.target, tc.expr, err)
+		}
+	}
+	if err := assign(dbg, "missing", "1"); err == nil {
+		t.Error("assigning an unknown variable succeeded")
+	}
+	if err := assign(dbg, "n", "missing"); err == nil {
+		t.Error("assigning an unknown variable's value succeeded")
+	}
+	if out := finish(t, dbg); out != "[\n   20,\n   20,\n   3,\n   200\n]\n" {
+		t.Errorf("got output %q", out)
+	}
+}
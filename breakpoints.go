@@ -0,0 +1,513 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// breakpoint holds what the frontend knows about a breakpoint. The debugger
+// itself only tracks locations and stops on every hit, everything else is
+// evaluated by the frontend when it stops.
+type breakpoint struct {
+	id     int
+	file   string
+	line   int
+	column int
+	// location is the breakpoint as returned by Debugger.SetBreakpoint.
+	location string
+	// at is the expression the breakpoint was set on, nil if it could not
+	// be set. message says why in that case, or else notes where the
+	// breakpoint stops.
+	at      *ast.LocationRange
+	message string
+	// pending is set for breakpoints in files the program has not loaded
+	// yet, they are set when it does.
+	pending bool
+	// literal is set for breakpoints on literals, which the debugger never
+	// stops at. The evaluation hooks stop there instead.
+	literal bool
+	// function is the name a function breakpoint was set for.
+	function string
+	// field is the name of the field a data breakpoint stops at the
+	// evaluation of, in any object unless object is set. layer is the
+	// object literal defining the field.
+	field        string
+	object       reflect.Value
+	layer        *ast.LocationRange
+	condition    string
+	hitCondition *hitCondition
+	// logMessage turns the breakpoint into a logpoint, which prints the
+	// interpolated message instead of stopping.
+	logMessage string
+	// temporary breakpoints are removed as soon as the debugger stops, they
+	// implement running to a location.
+	temporary bool
+	// hits counts how often the breakpoint was reached with its condition
+	// holding, whether or not the hit condition made it stop.
+	hits int
+}
+
+func (bp breakpoint) String() string {
+	s := bp.location
+	if bp.at == nil {
+		s = fmt.Sprintf("%s:%d (%s)", bp.file, bp.line, bp.message)
+	}
+	if bp.function != "" {
+		s = bp.function + " at " + s
+	}
+	if bp.field != "" {
+		s = "field " + bp.field + " at " + s
+	}
+	if bp.temporary {
+		s = "until " + s
+	}
+	if bp.condition != "" {
+		s += " if " + bp.condition
+	}
+	if bp.hitCondition != nil {
+		s += " hit " + bp.hitCondition.String()
+	}
+	if bp.logMessage != "" {
+		s += " log " + quote(bp.logMessage)
+	}
+	return fmt.Sprintf("%s (hits: %d)", s, bp.hits)
+}
+
+// key identifies bp when the breakpoints of its file are replaced: by
+// location once it is set, by the requested position otherwise.
+func (bp *breakpoint) key() string {
+	if bp.at != nil {
+		return bp.location
+	}
+	return fmt.Sprintf("%s:%d:%d", bp.file, bp.line, bp.column)
+}
+
+// set resolves the requested position of bp and sets it in the debugger,
+// under the name the file is loaded as. On failure, bp is left unverified
+// with the reason as message.
+func (bp *breakpoint) set(dbg *jsonnet.Debugger, name string) error {
+	bp.at, bp.location, bp.message, bp.pending, bp.literal = nil, "", "", false, false
+	at, err := breakpointTarget(name, bp.line, bp.column)
+	if err == nil {
+		bp.location, err = dbg.SetBreakpoint(name, at.Begin.Line, at.Begin.Column)
+	}
+	if err != nil {
+		bp.message = err.Error()
+		if errors.Is(err, fs.ErrNotExist) {
+			bp.pending = true
+			bp.message = "Pending until the file is loaded"
+		}
+		return err
+	}
+	bp.at = at
+	return nil
+}
+
+// setLiteral sets bp on the literal at, see breakpointTable.literals.
+func (bp *breakpoint) setLiteral(at ast.LocationRange) {
+	bp.at, bp.location, bp.message, bp.pending, bp.literal = &at, at.String(), "", false, true
+}
+
+// watches reports whether bp applies to the current evaluation. Data
+// breakpoints on the field of one object do not apply to other objects
+// defining the field in the same place.
+func (bp *breakpoint) watches(dbg *jsonnet.Debugger) bool {
+	if !bp.object.IsValid() {
+		return true
+	}
+	self, err := selfObject(dbg)
+	if err != nil {
+		slog.Warn("unable to check the object of a data breakpoint", "field", bp.field, "err", err)
+		return false
+	}
+	return self.IsValid() && self.Pointer() == bp.object.Pointer()
+}
+
+// place sets bp if the program has loaded its file already. Otherwise it is
+// left pending, as the debugger only stops at breakpoints set under the
+// name the importer finds the file under.
+func (bp *breakpoint) place(dbg *jsonnet.Debugger, sources *loadedSources) error {
+	if name, ok := sources.find(bp.file); ok {
+		return bp.set(dbg, name)
+	}
+	bp.at, bp.location, bp.message, bp.pending = nil, "", "", false
+	// Report invalid positions right away if the file can be read
+	if _, err := breakpointTarget(bp.file, bp.line, bp.column); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		bp.message = err.Error()
+		return err
+	}
+	bp.pending = true
+	bp.message = "Pending until the file is loaded"
+	return nil
+}
+
+// breakpointTarget returns the expression Debugger.SetBreakpoint sets a
+// breakpoint on: the first one the debugger can stop at on line, or the one
+// at column unless it is -1.
+func breakpointTarget(file string, line, column int) (*ast.LocationRange, error) {
+	targets, err := lineTargets(file, line, column)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) > 0 && (column < 0 || targets[0].Begin.Column == column) {
+		return targets[0], nil
+	}
+	if column < 0 {
+		return nil, fmt.Errorf("No expression the debugger can stop at starts on line %d, it does not stop at literals", line)
+	}
+	return nil, fmt.Errorf("No expression the debugger can stop at starts at line %d, column %d, it does not stop at literals", line, column)
+}
+
+// stopLocations returns the expressions of file the debugger can stop at,
+// like Debugger.BreakpointLocations without the literals, which the
+// debugger never stops at. Nested expressions starting at the same position
+// are only returned once, as the outermost one Debugger.SetBreakpoint picks.
+func stopLocations(file string) ([]*ast.LocationRange, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading file: %w", err)
+	}
+	node, err := jsonnet.SnippetToAST(file, string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid source file: %w", err)
+	}
+	seen := map[ast.Location]bool{}
+	locations := []*ast.LocationRange{}
+	walk(node, func(n ast.Node) {
+		switch n.(type) {
+		case *ast.LiteralNull, *ast.LiteralNumber, *ast.LiteralString, *ast.LiteralBoolean:
+			return
+		}
+		l := n.Loc()
+		if l.File == nil || seen[l.Begin] {
+			return
+		}
+		seen[l.Begin] = true
+		locations = append(locations, l)
+	})
+	return locations, nil
+}
+
+// lineTargets returns the stopLocations of file starting on line, from
+// column on if it is positive, sorted by column.
+func lineTargets(file string, line, column int) ([]*ast.LocationRange, error) {
+	locations, err := stopLocations(file)
+	if err != nil {
+		return nil, err
+	}
+	targets := []*ast.LocationRange{}
+	for _, l := range locations {
+		if l.Begin.Line == line && l.Begin.Column >= column {
+			targets = append(targets, l)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Begin.Column < targets[j].Begin.Column })
+	return targets, nil
+}
+
+// breakpointTable keeps track of the breakpoints set in a debugger. Several
+// breakpoints may share a location, e.g. a line breakpoint and a function
+// breakpoint on the first line of the function body.
+type breakpointTable struct {
+	mu     sync.Mutex
+	nextID int
+	bps    []*breakpoint
+}
+
+func newBreakpointTable() *breakpointTable {
+	return &breakpointTable{nextID: 1}
+}
+
+// newID allocates an id. Breakpoints that could not be set still need one
+// to be reported to DAP clients.
+func (t *breakpointTable) newID() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := t.nextID
+	t.nextID++
+	return id
+}
+
+func (t *breakpointTable) add(bp *breakpoint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bps = append(t.bps, bp)
+}
+
+// at returns the breakpoints the debugger stopped at when evaluating node.
+func (t *breakpointTable) at(node ast.Node) []*breakpoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	loc := node.Loc().String()
+	bps := []*breakpoint{}
+	for _, bp := range t.bps {
+		if bp.location == loc {
+			bps = append(bps, bp)
+		}
+	}
+	return bps
+}
+
+// snapshot returns a copy of bp that is safe to read while the debugger
+// keeps counting hits.
+func (t *breakpointTable) snapshot(bp *breakpoint) breakpoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return *bp
+}
+
+// list returns a snapshot of all breakpoints, ordered by id.
+func (t *breakpointTable) list() []breakpoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	bps := make([]breakpoint, 0, len(t.bps))
+	for _, bp := range t.bps {
+		bps = append(bps, *bp)
+	}
+	sort.SliceStable(bps, func(i, j int) bool { return bps[i].id < bps[j].id })
+	return bps
+}
+
+// clear removes the line breakpoints of file. The removed breakpoints are
+// returned by location, so their ids and hit counts can be carried over
+// when they are set again.
+func (t *breakpointTable) clear(dbg *jsonnet.Debugger, file string) map[string]*breakpoint {
+	abs, _ := filepath.Abs(file)
+	dropped, _ := t.drop(func(bp *breakpoint) bool {
+		full, err := filepath.Abs(bp.file)
+		return bp.function == "" && bp.field == "" && !bp.temporary && err == nil && full == abs
+	})
+	dbg.ClearBreakpoints(file)
+	t.restore(dbg, map[string]bool{abs: true})
+	removed := map[string]*breakpoint{}
+	for _, bp := range dropped {
+		removed[bp.key()] = bp
+	}
+	return removed
+}
+
+// resolve sets the pending breakpoints of the file loaded as name, and
+// returns them except for temporary ones.
+func (t *breakpointTable) resolve(dbg *jsonnet.Debugger, name string) []breakpoint {
+	abs, _ := filepath.Abs(name)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	resolved := []breakpoint{}
+	for _, bp := range t.bps {
+		full, err := filepath.Abs(bp.file)
+		if !bp.pending || err != nil || full != abs {
+			continue
+		}
+		if err := bp.set(dbg, name); err != nil {
+			slog.Warn("failed to set pending breakpoint", "file", name, "line", bp.line, "err", err)
+		}
+		if !bp.temporary {
+			resolved = append(resolved, *bp)
+		}
+	}
+	return resolved
+}
+
+// remove deletes the breakpoints matching pred, both from the table and
+// from the debugger.
+func (t *breakpointTable) remove(dbg *jsonnet.Debugger, pred func(*breakpoint) bool) []*breakpoint {
+	removed, files := t.drop(pred)
+	for file := range files {
+		dbg.ClearBreakpoints(file)
+	}
+	t.restore(dbg, files)
+	return removed
+}
+
+// removeTemporary deletes the temporary breakpoints, whether they were hit
+// or the debugger stopped for another reason first.
+func (t *breakpointTable) removeTemporary(dbg *jsonnet.Debugger) {
+	t.remove(dbg, func(bp *breakpoint) bool { return bp.temporary })
+}
+
+// drop deletes the breakpoints matching pred from the table and returns
+// them, along with the absolute paths of their files.
+func (t *breakpointTable) drop(pred func(*breakpoint) bool) ([]*breakpoint, map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	removed := []*breakpoint{}
+	kept := []*breakpoint{}
+	files := map[string]bool{}
+	for _, bp := range t.bps {
+		if pred(bp) {
+			removed = append(removed, bp)
+			abs, _ := filepath.Abs(bp.file)
+			files[abs] = true
+		} else {
+			kept = append(kept, bp)
+		}
+	}
+	t.bps = kept
+	return removed, files
+}
+
+// restore sets the remaining breakpoints of files in the debugger again, as
+// it can only clear whole files.
+func (t *breakpointTable) restore(dbg *jsonnet.Debugger, files map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, bp := range t.bps {
+		abs, _ := filepath.Abs(bp.file)
+		if !files[abs] || bp.at == nil || bp.literal {
+			continue
+		}
+		if _, err := dbg.SetBreakpoint(string(bp.at.File.DiagnosticFileName), bp.at.Begin.Line, bp.at.Begin.Column); err != nil {
+			slog.Warn("failed to restore breakpoint", "breakpoint", bp.location, "err", err)
+		}
+	}
+}
+
+// literals returns the positions of the breakpoints set on literals, for
+// evalHooks.breakAtLiterals.
+func (t *breakpointTable) literals() []ast.LocationRange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	positions := []ast.LocationRange{}
+	for _, bp := range t.bps {
+		if bp.literal {
+			positions = append(positions, *bp.at)
+		}
+	}
+	return positions
+}
+
+// breakpointHit is the outcome of checking a breakpoint the debugger
+// stopped at.
+type breakpointHit struct {
+	bp   *breakpoint
+	stop bool
+	// logMsg is the interpolated message of a logpoint
+	logMsg string
+	// err is set if the condition could not be evaluated
+	err error
+}
+
+// check evaluates all breakpoints at node. The debugger should stop if any
+// of them says so, or if there are none as the stop is then not caused by
+// one of the frontend's breakpoints.
+func (t *breakpointTable) check(dbg *jsonnet.Debugger, node ast.Node) (bool, []breakpointHit) {
+	bps := t.at(node)
+	if len(bps) == 0 {
+		return true, nil
+	}
+	stop := false
+	hits := []breakpointHit{}
+	for _, bp := range bps {
+		if !bp.watches(dbg) {
+			continue
+		}
+		h := t.shouldStop(dbg, bp)
+		stop = stop || h.stop
+		hits = append(hits, h)
+	}
+	return stop, hits
+}
+
+// shouldStop decides whether to stop at bp and counts the hit. Errors
+// evaluating the condition stop, as the user needs to know about them.
+// Logpoints never stop: when their conditions hold, the interpolated
+// message is returned instead.
+func (t *breakpointTable) shouldStop(dbg *jsonnet.Debugger, bp *breakpoint) breakpointHit {
+	stop, err := t.hit(dbg, bp)
+	if stop && err == nil && bp.logMessage != "" {
+		return breakpointHit{bp: bp, logMsg: interpolateMessage(dbg, bp.logMessage)}
+	}
+	return breakpointHit{bp: bp, stop: stop, err: err}
+}
+
+// hit evaluates the conditions of bp and counts the hit.
+func (t *breakpointTable) hit(dbg *jsonnet.Debugger, bp *breakpoint) (bool, error) {
+	if bp.condition != "" {
+		ok, err := evaluateCondition(dbg, bp.condition)
+		if err != nil {
+			return true, err
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	bp.hits++
+	if bp.hitCondition != nil {
+		return bp.hitCondition.matches(bp.hits), nil
+	}
+	return true, nil
+}
+
+// parseLocation parses the `file:line[:column]` notation used by the REPL.
+// The column is -1 if not specified.
+func parseLocation(spec string) (file string, line int, column int, err error) {
+	binfo := strings.Split(spec, ":")
+	if len(binfo) < 2 {
+		return "", 0, 0, fmt.Errorf("must specify file and line separated by `:`")
+	}
+	line, err = strconv.Atoi(binfo[1])
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid line number: %w", err)
+	}
+	column = -1
+	if len(binfo) == 3 {
+		column, err = strconv.Atoi(binfo[2])
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("invalid column number: %w", err)
+		}
+	}
+	return binfo[0], line, column, nil
+}
+
+// hitCondition is a parsed hit count expression: `==N`, `>=N` or `%N`. A
+// plain number is treated like `==N`.
+type hitCondition struct {
+	op string
+	n  int
+}
+
+func parseHitCondition(s string) (*hitCondition, error) {
+	raw := s
+	s = strings.TrimSpace(s)
+	op := "=="
+	for _, prefix := range []string{"==", ">=", "%"} {
+		if strings.HasPrefix(s, prefix) {
+			op = prefix
+			s = strings.TrimSpace(s[len(prefix):])
+			break
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid hit condition %q: expected ==N, >=N or %%N with N > 0", raw)
+	}
+	return &hitCondition{op: op, n: n}, nil
+}
+
+func (h *hitCondition) matches(hits int) bool {
+	switch h.op {
+	case ">=":
+		return hits >= h.n
+	case "%":
+		return hits%h.n == 0
+	}
+	return hits == h.n
+}
+
+func (h *hitCondition) String() string {
+	return h.op + strconv.Itoa(h.n)
+}
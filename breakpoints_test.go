@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-dap"
+	"github.com/google/go-jsonnet"
+)
+
+func TestParseHitCondition(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+		hits []int
+	}{
+		{in: "3", want: "==3", hits: []int{3}},
+		{in: "==2", want: "==2", hits: []int{2}},
+		{in: " >= 4 ", want: ">=4", hits: []int{4, 5, 6}},
+		{in: "%2", want: "%2", hits: []int{2, 4, 6}},
+	} {
+		h, err := parseHitCondition(test.in)
+		if err != nil {
+			t.Errorf("%q: %v", test.in, err)
+			continue
+		}
+		if h.String() != test.want {
+			t.Errorf("%q parsed as %s, want %s", test.in, h, test.want)
+		}
+		var hits []int
+		for i := 1; i <= 6; i++ {
+			if h.matches(i) {
+				hits = append(hits, i)
+			}
+		}
+		if !slices.Equal(hits, test.hits) {
+			t.Errorf("%q matches hits %v, want %v", test.in, hits, test.hits)
+		}
+	}
+	for _, in := range []string{"", "0", "==-1", "<3", "%x", ">=", "1.5"} {
+		if _, err := parseHitCondition(in); err == nil {
+			t.Errorf("%q was accepted", in)
+		}
+	}
+}
+
+func TestBreakpointConditions(t *testing.T) {
+	src := `local f(x) = x * 2;
+[f(i) for i in std.range(1, 5)]
+`
+	filename := writeFile(t, src)
+	atLeast4, _ := parseHitCondition(">=4")
+	table := newBreakpointTable()
+	dbg := jsonnet.MakeDebugger()
+	for i, bp := range []*breakpoint{
+		{condition: "x > 2"},
+		{hitCondition: atLeast4},
+		{condition: "x % 2 == 1", logMessage: "x is {x}"},
+		{condition: "y > 2"},
+	} {
+		bp.id, bp.file, bp.line, bp.column = i+1, filename, 1, 14
+		if err := bp.set(dbg, filename); err != nil {
+			t.Fatal(err)
+		}
+		table.add(bp)
+	}
+	dbg.Launch(filename, src, nil)
+
+	var got []string
+	for stop := waitStop(t, dbg); stop != nil; stop = waitStop(t, dbg) {
+		x, err := evaluate(dbg, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, hits := table.check(dbg, stop.Current)
+		for _, h := range hits {
+			switch {
+			case h.err != nil:
+				got = append(got, fmt.Sprintf("x=%s %d: error", x, h.bp.id))
+			case h.logMsg != "":
+				got = append(got, fmt.Sprintf("x=%s %d: log %s", x, h.bp.id, h.logMsg))
+			case h.stop:
+				got = append(got, fmt.Sprintf("x=%s %d: stop", x, h.bp.id))
+			}
+		}
+		dbg.Continue()
+	}
+	want := []string{
+		"x=1 3: log x is 1", "x=1 4: error",
+		"x=2 4: error",
+		"x=3 1: stop", "x=3 3: log x is 3", "x=3 4: error",
+		"x=4 1: stop", "x=4 2: stop", "x=4 4: error",
+		"x=5 1: stop", "x=5 2: stop", "x=5 3: log x is 5", "x=5 4: error",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	hits := map[int]int{}
+	for _, bp := range table.list() {
+		hits[bp.id] = bp.hits
+	}
+	// Hits only count when the condition holds, a failing condition stops
+	// without counting
+	if want := map[int]int{1: 3, 2: 5, 3: 3, 4: 0}; !maps.Equal(hits, want) {
+		t.Errorf("got hit counts %v, want %v", hits, want)
+	}
+}
+
+func TestLineBreakpointsSkipLiterals(t *testing.T) {
+	var locations []dap.BreakpointLocation
+	var set []dap.Breakpoint
+	c := launchSession(t, `{
+  a: 1,
+  b: [
+    2,
+  ],
+  c: self.a + 1,
+}
+`, func(c *testClient, path string) {
+		c.send("breakpointLocations", dap.BreakpointLocationsArguments{Source: dap.Source{Path: path}, Line: 1, EndLine: 7})
+		locations = await[*dap.BreakpointLocationsResponse](c).Body.Breakpoints
+		c.send("setBreakpoints", dap.SetBreakpointsArguments{
+			Source:      dap.Source{Path: path},
+			Breakpoints: []dap.SourceBreakpoint{{Line: 2}, {Line: 4}, {Line: 6}},
+		})
+		set = await[*dap.SetBreakpointsResponse](c).Body.Breakpoints
+	})
+	var got []string
+	for _, l := range locations {
+		got = append(got, fmt.Sprintf("%d:%d", l.Line, l.Column))
+	}
+	if want := []string{"1:1", "3:6", "6:6"}; !slices.Equal(got, want) {
+		t.Errorf("got breakpoint locations %q, want %q", got, want)
+	}
+	for _, bp := range set[:2] {
+		if bp.Verified || !strings.Contains(bp.Message, "does not stop at literals") {
+			t.Errorf("breakpoint on line %d: verified %v: %s", bp.Line, bp.Verified, bp.Message)
+		}
+	}
+	// The breakpoint on line 6 is set once the program loads the file
+	if set[2].Verified || !strings.HasPrefix(set[2].Message, "Pending") {
+		t.Errorf("breakpoint on line 6: verified %v before launching: %s", set[2].Verified, set[2].Message)
+	}
+	changed := await[*dap.BreakpointEvent](c).Body
+	if bp := changed.Breakpoint; changed.Reason != "changed" || bp.Id != set[2].Id || !bp.Verified || bp.Line != 6 || bp.Column != 6 {
+		t.Errorf("got %s event for breakpoint %d, verified %v at %d:%d, want breakpoint %d verified at 6:6",
+			changed.Reason, bp.Id, bp.Verified, bp.Line, bp.Column, set[2].Id)
+	}
+	if stopped := await[*dap.StoppedEvent](c); stopped.Body.Reason != "breakpoint" {
+		t.Fatalf("stopped for %s, want the breakpoint on line 6", stopped.Body.Reason)
+	}
+	c.finish()
+}
+
+func TestPendingBreakpointsInLibraryPaths(t *testing.T) {
+	lib := t.TempDir()
+	libFile := filepath.Join(lib, "lib.libsonnet")
+	if err := os.WriteFile(libFile, []byte("{\n  double(x):: x * 2,\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := writeFile(t, "local lib = import 'lib.libsonnet';\nlib.double(21)\n")
+	var set []dap.Breakpoint
+	c := launchWith(t, map[string]any{"program": path, "jpaths": []string{lib}}, func(c *testClient) {
+		c.send("setBreakpoints", dap.SetBreakpointsArguments{
+			Source:      dap.Source{Path: libFile},
+			Breakpoints: []dap.SourceBreakpoint{{Line: 2}},
+		})
+		set = await[*dap.SetBreakpointsResponse](c).Body.Breakpoints
+	})
+	// The file exists but the debugger only stops at breakpoints set under
+	// the name the importer finds it under
+	if set[0].Verified || !strings.HasPrefix(set[0].Message, "Pending") {
+		t.Errorf("verified %v before the file was imported: %s", set[0].Verified, set[0].Message)
+	}
+	changed := await[*dap.BreakpointEvent](c).Body
+	if bp := changed.Breakpoint; changed.Reason != "changed" || !bp.Verified || bp.Line != 2 {
+		t.Errorf("got %s event, verified %v at line %d, want the breakpoint verified at line 2", changed.Reason, bp.Verified, bp.Line)
+	}
+	if stopped := await[*dap.StoppedEvent](c); stopped.Body.Reason != "breakpoint" {
+		t.Fatalf("stopped for %s, want the breakpoint in the library", stopped.Body.Reason)
+	}
+	if x := c.evaluate("x"); x != "21" {
+		t.Errorf("x = %s, want 21", x)
+	}
+	c.finish()
+}
+
+func TestBreakpointTarget(t *testing.T) {
+	filename := writeFile(t, "{\n  a: 1,\n  b: [self.a, 2],\n}\n")
+	for _, test := range []struct {
+		line, column int
+		want         string
+	}{
+		{line: 1, column: -1, want: "1:1"},
+		{line: 2, column: -1, want: ""},
+		{line: 3, column: -1, want: "3:6"},
+		{line: 3, column: 7, want: "3:7"},
+		{line: 3, column: 15, want: ""},
+	} {
+		var got string
+		if at, err := breakpointTarget(filename, test.line, test.column); err == nil {
+			got = at.Begin.String()
+		}
+		if got != test.want {
+			t.Errorf("%d:%d: got target %q, want %q", test.line, test.column, got, test.want)
+		}
+	}
+}
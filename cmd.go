@@ -31,6 +31,8 @@ func usage(o io.Writer) {
 	fmt.Fprintln(o, "  -d / --dap                 Start a debug-adapter-protocol server")
 	fmt.Fprintln(o, "  -s / --stdin               Start a debug-adapter-protocol session using stdion/stdout for communication")
 	fmt.Fprintln(o, "  -l / --log-level           Set the log level. Allowed values: debug,info,warn,error")
+	fmt.Fprintln(o, "  --restart-frames           Enable reeval and restarting frames over DAP, which replay a")
+	fmt.Fprintln(o, "                             stack frame. It does not rewind the evaluation, and slows it down")
 	fmt.Fprintln(o, "  --version                  Print version")
 	fmt.Fprintln(o)
 	fmt.Fprintln(o, "In all cases:")
@@ -47,6 +49,7 @@ type config struct {
 	jpath          []string
 	logLevel       slog.Level
 	stdin          bool
+	restartFrames  bool
 }
 
 type processArgsStatus int
@@ -122,6 +125,8 @@ func processArgs(givenArgs []string, config *config) (processArgsStatus, error)
 				return processArgsStatusFailure, fmt.Errorf("-J argument was empty string")
 			}
 			config.jpath = append(config.jpath, dir)
+		} else if arg == "--restart-frames" {
+			config.restartFrames = true
 		} else if arg == "-d" || arg == "--dap" {
 			config.dap = true
 		} else if arg == "-l" || arg == "--log-level" {
@@ -246,9 +251,9 @@ func main() {
 	if config.dap {
 		var err error
 		if config.stdin {
-			err = dapStdin()
+			err = dapStdin(config.restartFrames)
 		} else {
-			err = dapServer("54321")
+			err = dapServer("54321", config.restartFrames)
 		}
 		if err != nil {
 			slog.Error("dap server terminated", "err", err)
@@ -261,6 +266,6 @@ func main() {
 	if !config.filenameIsCode {
 		config.jpath = append(config.jpath, path.Dir(inputFile))
 	}
-	repl := MakeReplDebugger(inputFile, input, config.jpath)
+	repl := MakeReplDebugger(inputFile, input, config.jpath, config.restartFrames)
 	repl.Run()
 }
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+)
+
+// completion is a candidate for completing an expression.
+type completion struct {
+	label string
+	// kind is the type of the DAP completion item, such as "variable".
+	kind   string
+	detail string
+}
+
+// complete returns the completions of the expression text, with the cursor
+// at its end, and the prefix of text they replace. After a `.`, the fields
+// of the object before it are completed, including hidden ones, and the
+// functions of the standard library after `std.`. Otherwise the variables
+// in scope and keywords are completed.
+func complete(dbg *jsonnet.Debugger, text string) (string, []completion) {
+	start := len(text)
+	for start > 0 && isIdentifierByte(text[start-1]) {
+		start--
+	}
+	prefix := text[start:]
+	var candidates []completion
+	if start > 0 && text[start-1] == '.' {
+		target := objectExpression(text[:start-1])
+		switch target {
+		case "":
+		case "std":
+			candidates = stdCompletions(dbg)
+		default:
+			candidates = fieldCompletions(dbg, target)
+		}
+	} else {
+		candidates = scopeCompletions(dbg)
+	}
+	matches := []completion{}
+	for _, c := range candidates {
+		if strings.HasPrefix(c.label, prefix) {
+			matches = append(matches, c)
+		}
+	}
+	return prefix, matches
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// objectExpression returns the expression at the end of text that a field
+// is accessed on, such as `self.spec["items"][0]` or `(a + b)`.
+func objectExpression(text string) string {
+	start := len(text)
+	for start > 0 {
+		c := text[start-1]
+		switch {
+		case isIdentifierByte(c) || c == '.' || c == '$':
+			start--
+		case c == ']' || c == ')':
+			open := byte('[')
+			if c == ')' {
+				open = '('
+			}
+			depth := 0
+			i := start - 1
+			for ; i >= 0; i-- {
+				if text[i] == c {
+					depth++
+				} else if text[i] == open {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			if i < 0 {
+				return ""
+			}
+			start = i
+		default:
+			return text[start:]
+		}
+	}
+	return text[start:]
+}
+
+// scopeCompletions lists the variables of the current environment, followed
+// by the keywords.
+func scopeCompletions(dbg *jsonnet.Debugger) []completion {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, v := range dbg.ListVars() {
+		name := string(v)
+		if seen[name] || strings.HasPrefix(name, "$") {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := []completion{}
+	for _, name := range names {
+		out = append(out, completion{label: name, kind: "variable"})
+	}
+	kw := []string{}
+	for k := range keywords {
+		if !seen[k] {
+			kw = append(kw, k)
+		}
+	}
+	if !seen["std"] {
+		kw = append(kw, "std")
+	}
+	sort.Strings(kw)
+	for _, k := range kw {
+		out = append(out, completion{label: k, kind: "keyword"})
+	}
+	return out
+}
+
+// stdCompletions lists the functions of the standard library with their
+// signature.
+func stdCompletions(dbg *jsonnet.Debugger) []completion {
+	functions, err := stdFunctions(dbg)
+	if err != nil {
+		slog.Debug("unable to list the standard library", "err", err)
+		return nil
+	}
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := []completion{}
+	for _, name := range names {
+		out = append(out, completion{
+			label:  name,
+			kind:   "function",
+			detail: "std." + name + "(" + strings.Join(functions[name], ", ") + ")",
+		})
+	}
+	return out
+}
+
+// fieldCompletions lists the fields of the object evaluated by expr. Fields
+// that cannot be accessed with `.` are left out.
+func fieldCompletions(dbg *jsonnet.Debugger, expr string) []completion {
+	hidden, err := fieldsOf(dbg, expr)
+	if err != nil {
+		slog.Debug("unable to list fields", "expr", expr, "err", err)
+		return nil
+	}
+	names := []string{}
+	for name := range hidden {
+		if identifierPattern.MatchString(name) && !keywords[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	out := []completion{}
+	for _, name := range names {
+		c := completion{label: name, kind: "field"}
+		if hidden[name] {
+			c.detail = "hidden"
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// fieldsOf returns the fields of the object evaluated by expr, with whether
+// they are hidden. Objects reached through variables, self and $ are
+// inspected directly, other expressions are evaluated.
+func fieldsOf(dbg *jsonnet.Debugger, expr string) (map[string]bool, error) {
+	node, _, err := formatter.SnippetToRawAST("<completion>", expr)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := runtimeValue(dbg, node)
+	if err != nil {
+		slog.Debug("unable to inspect value", "expr", expr, "err", err)
+	}
+	if isValueType(obj, "valueObject") {
+		return objectFields(obj)
+	}
+	obj, err = evaluateValue(dbg, expr)
+	if err != nil {
+		return nil, err
+	}
+	if !isValueType(obj, "valueObject") {
+		return nil, fmt.Errorf("%s is not an object", expr)
+	}
+	return objectFields(obj)
+}
+
+// runtimeValue returns the value of the interpreter node evaluates to, if
+// it is a variable, self or $ followed by field accesses and array indexes
+// that have already been evaluated. The result is invalid otherwise.
+func runtimeValue(dbg *jsonnet.Debugger, node ast.Node) (reflect.Value, error) {
+	switch n := node.(type) {
+	case *ast.Var:
+		return variableValue(dbg, string(n.Id))
+	case *ast.Self:
+		return selfObject(dbg)
+	case *ast.Dollar:
+		// $ is bound to the outermost object, evaluating it cannot fail
+		return forceVariable(dbg, "$")
+	case *ast.Parens:
+		return runtimeValue(dbg, n.Inner)
+	case *ast.Index:
+		target, err := runtimeValue(dbg, n.Target)
+		if err != nil || !target.IsValid() {
+			return reflect.Value{}, err
+		}
+		if n.Id != nil {
+			if !isValueType(target, "valueObject") {
+				return reflect.Value{}, nil
+			}
+			return objectField(target, string(*n.Id))
+		}
+		switch index := n.Index.(type) {
+		case *ast.LiteralString:
+			if !isValueType(target, "valueObject") {
+				return reflect.Value{}, nil
+			}
+			return objectField(target, index.Value)
+		case *ast.LiteralNumber:
+			i, err := strconv.Atoi(index.OriginalString)
+			if err != nil || !isValueType(target, "valueArray") {
+				return reflect.Value{}, nil
+			}
+			return arrayElement(target, i)
+		}
+	}
+	return reflect.Value{}, nil
+}
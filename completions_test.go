@@ -0,0 +1,42 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	dbg := stopAt(t, `local config = { name: 'app', replicas: 2, internal:: true, 'not-an-id': 1 };
+local helper(value) =
+  [value, config.replicas];
+{
+  out: helper(config.name),
+  other: 1,
+}
+`, 3, -1)
+	for _, test := range []struct {
+		text, prefix string
+		want         []string
+	}{
+		{text: "val", prefix: "val", want: []string{"value"}},
+		// Only the variables the function uses are in its closure
+		{text: "co", prefix: "co", want: []string{"config"}},
+		{text: "he", prefix: "he", want: []string{}},
+		{text: "config.", prefix: "", want: []string{"internal", "name", "replicas"}},
+		{text: "config.re", prefix: "re", want: []string{"replicas"}},
+		{text: "$.", prefix: "", want: []string{"other", "out"}},
+		{text: "1 + $.o", prefix: "o", want: []string{"other", "out"}},
+		{text: "std.manifestYaml", prefix: "manifestYaml", want: []string{"manifestYamlDoc", "manifestYamlStream"}},
+		{text: "missing.", prefix: "", want: []string{}},
+	} {
+		prefix, got := complete(dbg, test.text)
+		labels := []string{}
+		for _, c := range got {
+			labels = append(labels, c.label)
+		}
+		if prefix != test.prefix || !slices.Equal(labels, test.want) {
+			t.Errorf("%q: got %q replacing %q, want %q replacing %q", test.text, labels, prefix, test.want, test.prefix)
+		}
+	}
+	finish(t, dbg)
+}
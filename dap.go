@@ -10,15 +10,20 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"unicode/utf16"
 
 	"github.com/google/go-dap"
 	"github.com/google/go-jsonnet"
 	"github.com/google/go-jsonnet/ast"
 )
 
-func dapServer(port string) error {
+func dapServer(port string, restartFrames bool) error {
 	listener, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		return err
@@ -34,17 +39,20 @@ func dapServer(port string) error {
 		}
 		slog.Info("Accepted connection", "remote", conn.RemoteAddr())
 		// Handle multiple client connections concurrently
-		go handleConnection(conn)
+		go handleConnection(conn, restartFrames)
 	}
 }
 
-func dapStdin() error {
+func dapStdin(restartFrames bool) error {
 	slog.Info("starting DAP using STDIN/STDOUT as communication protocol")
 	debugSession := JsonnetDebugSession{
-		rw:        bufio.NewReadWriter(bufio.NewReader(os.Stdin), bufio.NewWriter(os.Stdout)),
-		sendQueue: make(chan dap.Message),
-		stopDebug: make(chan struct{}),
-		debugger:  jsonnet.MakeDebugger(),
+		rw:            bufio.NewReadWriter(bufio.NewReader(os.Stdin), bufio.NewWriter(os.Stdout)),
+		sendQueue:     make(chan dap.Message),
+		stopDebug:     make(chan struct{}),
+		debugger:      jsonnet.MakeDebugger(),
+		breakpoints:   newBreakpointTable(),
+		exceptions:    newExceptionBreakpoints(),
+		restartFrames: restartFrames,
 	}
 	debugSession.configurationDoneEvent.Add(1)
 
@@ -71,12 +79,15 @@ func dapStdin() error {
 	return nil
 }
 
-func handleConnection(conn net.Conn) {
+func handleConnection(conn net.Conn, restartFrames bool) {
 	debugSession := JsonnetDebugSession{
-		rw:        bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
-		sendQueue: make(chan dap.Message),
-		stopDebug: make(chan struct{}),
-		debugger:  jsonnet.MakeDebugger(),
+		rw:            bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
+		sendQueue:     make(chan dap.Message),
+		stopDebug:     make(chan struct{}),
+		debugger:      jsonnet.MakeDebugger(),
+		breakpoints:   newBreakpointTable(),
+		exceptions:    newExceptionBreakpoints(),
+		restartFrames: restartFrames,
 	}
 	debugSession.configurationDoneEvent.Add(1)
 
@@ -123,35 +134,176 @@ func (ds *JsonnetDebugSession) dispatchEvents() {
 	var e dap.Message
 	for {
 		event := <-echan
+		var exception *jsonnet.DebugEventStop
 		switch ev := event.(type) {
 		case *jsonnet.DebugEventStop:
 			ds.current = ev.Current
 			switch ev.Reason {
 			case jsonnet.StopReasonBreakpoint:
-				e = &dap.StoppedEvent{
-					Event: *newEvent("stopped"),
-					Body:  dap.StoppedEventBody{Reason: "breakpoint", ThreadId: 1, AllThreadsStopped: true},
+				stop, stoppedAt := ds.stopAtBreakpoint(ev)
+				if !stop {
+					ds.debugger.Continue()
+					continue
 				}
+				ds.stepper.reset()
+				ds.hooks.cancelPause()
+				e = breakpointStoppedEvent(stoppedAt)
 			case jsonnet.StopReasonStep:
+				if ds.restarter.restarted() {
+					e = &dap.StoppedEvent{
+						Event: *newEvent("stopped"),
+						Body: dap.StoppedEventBody{
+							Reason:            "restart",
+							Description:       "Replaying the frame",
+							Text:              replayNote,
+							ThreadId:          1,
+							AllThreadsStopped: true,
+						},
+					}
+					break
+				}
+				if ds.hooks.paused() {
+					ds.stepper.reset()
+					e = &dap.StoppedEvent{
+						Event: *newEvent("stopped"),
+						Body:  dap.StoppedEventBody{Reason: "pause", ThreadId: 1, AllThreadsStopped: true},
+					}
+					break
+				}
+				if ds.stepper.stepping() {
+					// The debugger reports breakpoints as steps while stepping
+					if stop, stoppedAt := ds.stopAtBreakpoint(ev); stop && len(stoppedAt) > 0 {
+						ds.stepper.reset()
+						e = breakpointStoppedEvent(stoppedAt)
+						break
+					}
+					if !ds.stepper.done(ev) {
+						ds.debugger.Step()
+						continue
+					}
+				}
 				e = &dap.StoppedEvent{
 					Event: *newEvent("stopped"),
 					Body:  dap.StoppedEventBody{Reason: "step", ThreadId: 1, AllThreadsStopped: true},
 				}
 			case jsonnet.StopReasonException:
+				filter, stop := ds.exceptions.shouldStop(ev)
+				if !stop {
+					ds.debugger.Continue()
+					continue
+				}
+				ds.stepper.reset()
+				ds.hooks.cancelPause()
+				exception = ev
 				e = &dap.StoppedEvent{
 					Event: *newEvent("stopped"),
-					Body:  dap.StoppedEventBody{Reason: "exception", ThreadId: 1, AllThreadsStopped: true, Text: ev.Error.Error()},
+					Body: dap.StoppedEventBody{
+						Reason:            "exception",
+						Description:       "Paused on " + strings.ToLower(findExceptionFilter(filter).Label),
+						ThreadId:          1,
+						AllThreadsStopped: true,
+						Text:              ev.Error.Error(),
+					},
 				}
 			}
 		case *jsonnet.DebugEventExit:
+			if ev.Error != nil {
+				// Errors are not necessarily stopped at, depending on the
+				// exception filters
+				ds.send(&dap.OutputEvent{
+					Event: *newEvent("output"),
+					Body:  dap.OutputEventBody{Category: "stderr", Output: ev.Error.Error() + "\n"},
+				})
+			}
 			e = &dap.TerminatedEvent{
 				Event: *newEvent("terminated"),
 			}
 		}
+		// References from the previous stop are no longer valid
+		ds.variables.reset()
+		ds.breakpoints.removeTemporary(ds.debugger)
+		ds.exceptions.setStoppedAt(exception)
 		ds.send(e)
 	}
 }
 
+func breakpointStoppedEvent(stoppedAt []breakpoint) *dap.StoppedEvent {
+	reason := "breakpoint"
+	description := ""
+	ids := []int{}
+	for _, bp := range stoppedAt {
+		if bp.temporary {
+			continue
+		}
+		ids = append(ids, bp.id)
+		switch {
+		case bp.function != "":
+			reason = "function breakpoint"
+		case bp.field != "":
+			reason = "data breakpoint"
+			description = fmt.Sprintf("Paused on field %s, defined by the object at %s", bp.field, bp.layer)
+		}
+	}
+	if len(ids) == 0 {
+		// Only the temporary breakpoint of a goto request was hit
+		reason = "goto"
+	}
+	return &dap.StoppedEvent{
+		Event: *newEvent("stopped"),
+		Body: dap.StoppedEventBody{
+			Reason:            reason,
+			Description:       description,
+			ThreadId:          1,
+			AllThreadsStopped: true,
+			HitBreakpointIds:  ids,
+		},
+	}
+}
+
+// stopAtBreakpoint evaluates the conditions and hit conditions of the
+// breakpoints the debugger stopped at, and returns whether to stop along
+// with the breakpoints that caused it. Conditions that fail to evaluate are
+// reported back to the client and stop the evaluation, otherwise the client
+// is sent the updated hit count when stopping. Logpoints send their message
+// as output and never stop.
+func (ds *JsonnetDebugSession) stopAtBreakpoint(ev *jsonnet.DebugEventStop) (bool, []breakpoint) {
+	stop, hits := ds.breakpoints.check(ds.debugger, ev.Current)
+	stoppedAt := []breakpoint{}
+	for _, h := range hits {
+		bp := ds.breakpoints.snapshot(h.bp)
+		switch {
+		case h.logMsg != "":
+			ds.send(&dap.OutputEvent{
+				Event: *newEvent("output"),
+				Body: dap.OutputEventBody{
+					Category: "console",
+					Output:   h.logMsg + "\n",
+					Source:   &dap.Source{Path: bp.file},
+					Line:     bp.line,
+				},
+			})
+		case h.err != nil:
+			b := dapBreakpoint(bp)
+			b.Verified = false
+			b.Message = "Failed to evaluate condition: " + h.err.Error()
+			ds.send(&dap.BreakpointEvent{
+				Event: *newEvent("breakpoint"),
+				Body:  dap.BreakpointEventBody{Reason: "changed", Breakpoint: b},
+			})
+			stoppedAt = append(stoppedAt, bp)
+		case h.stop && bp.temporary:
+			stoppedAt = append(stoppedAt, bp)
+		case h.stop:
+			ds.send(&dap.BreakpointEvent{
+				Event: *newEvent("breakpoint"),
+				Body:  dap.BreakpointEventBody{Reason: "changed", Breakpoint: dapBreakpoint(bp)},
+			})
+			stoppedAt = append(stoppedAt, bp)
+		}
+	}
+	return stop, stoppedAt
+}
+
 // dispatchRequest launches a new goroutine to process each request
 // and send back events and responses.
 func (ds *JsonnetDebugSession) dispatchRequest(request dap.Message) {
@@ -285,10 +437,55 @@ type JsonnetDebugSession struct {
 	// stopDebug is used to notify long-running handlers to stop processing.
 	stopDebug chan struct{}
 
-	// bpSet is a counter of the remaining breakpoints that the debug
-	// session is yet to stop at before the program terminates.
-	bpSet    int
-	bpSetMux sync.Mutex
+	// breakpoints holds the conditions of the breakpoints set in the debugger.
+	breakpoints *breakpointTable
+
+	// exceptions selects the errors to stop at.
+	exceptions *exceptionBreakpoints
+
+	// variables holds the references to the scopes and values the client
+	// can expand.
+	variables variableHandles
+
+	// frames selects the stack frame to inspect.
+	frames frameSelector
+
+	// stepper keeps stepping until a step out is complete.
+	stepper stepper
+
+	// hooks follow the evaluation, to pause it, step out and restart
+	// frames.
+	hooks evalHooks
+
+	// gotoTargets are the locations the client can run to.
+	gotoTargets gotoTargets
+
+	// restarter evaluates stack frames again. restartFrames enables it
+	// for every session, otherwise it is enabled by the launch arguments.
+	restarter     frameRestarter
+	restartFrames bool
+
+	// sources are the files loaded by the program.
+	sources loadedSources
+
+	// virtual serves the sources without a file on disk.
+	virtual virtualSources
+
+	// invalidatedEvents is set if the client refreshes its views when sent
+	// invalidated events.
+	invalidatedEvents atomic.Bool
+
+	// launchMux guards the launch arguments, the parsed program and the
+	// function and data breakpoints, which can only be resolved once the
+	// program is known.
+	launchMux           sync.Mutex
+	launchArgs          launchRequest
+	program             []*programFile
+	functionBreakpoints []functionBreakpoint
+	dataBreakpoints     []dataBreakpoint
+	// watched are the objects data breakpoints can be restricted to,
+	// referenced by their index in data breakpoint ids.
+	watched []reflect.Value
 
 	debugger *jsonnet.Debugger
 	current  ast.Node
@@ -303,41 +500,43 @@ type JsonnetDebugSession struct {
 // and use their results to populate each response.
 
 func (ds *JsonnetDebugSession) onInitializeRequest(request *dap.InitializeRequest) {
+	ds.invalidatedEvents.Store(request.Arguments.SupportsInvalidatedEvent)
 
 	response := &dap.InitializeResponse{}
 	response.Response = *newResponse(request.Seq, request.Command)
 	response.Body.SupportsConfigurationDoneRequest = true
-	response.Body.SupportsFunctionBreakpoints = false
-	response.Body.SupportsConditionalBreakpoints = false
-	response.Body.SupportsHitConditionalBreakpoints = false
-	response.Body.SupportsEvaluateForHovers = false
-	response.Body.ExceptionBreakpointFilters = []dap.ExceptionBreakpointsFilter{}
+	response.Body.SupportsFunctionBreakpoints = true
+	response.Body.SupportsConditionalBreakpoints = true
+	response.Body.SupportsHitConditionalBreakpoints = true
+	response.Body.SupportsEvaluateForHovers = true
+	response.Body.ExceptionBreakpointFilters = exceptionBreakpointFilters
+	response.Body.SupportsExceptionFilterOptions = true
 	response.Body.SupportsStepBack = false
-	response.Body.SupportsSetVariable = false
-	response.Body.SupportsRestartFrame = false
-	response.Body.SupportsGotoTargetsRequest = false
-	response.Body.SupportsStepInTargetsRequest = false
-	response.Body.SupportsCompletionsRequest = false
-	response.Body.CompletionTriggerCharacters = []string{}
+	response.Body.SupportsSetVariable = true
+	response.Body.SupportsRestartFrame = ds.restartFrames
+	response.Body.SupportsGotoTargetsRequest = true
+	response.Body.SupportsStepInTargetsRequest = true
+	response.Body.SupportsCompletionsRequest = true
+	response.Body.CompletionTriggerCharacters = []string{"."}
 	response.Body.SupportsModulesRequest = false
 	response.Body.AdditionalModuleColumns = []dap.ColumnDescriptor{}
 	response.Body.SupportedChecksumAlgorithms = []dap.ChecksumAlgorithm{}
 	response.Body.SupportsRestartRequest = false
 	response.Body.SupportsExceptionOptions = false
 	response.Body.SupportsValueFormattingOptions = false
-	response.Body.SupportsExceptionInfoRequest = false
+	response.Body.SupportsExceptionInfoRequest = true
 	response.Body.SupportTerminateDebuggee = false
 	response.Body.SupportsDelayedStackTraceLoading = false
-	response.Body.SupportsLoadedSourcesRequest = false
-	response.Body.SupportsLogPoints = false
+	response.Body.SupportsLoadedSourcesRequest = true
+	response.Body.SupportsLogPoints = true
 	response.Body.SupportsTerminateThreadsRequest = false
-	response.Body.SupportsSetExpression = false
+	response.Body.SupportsSetExpression = true
 	response.Body.SupportsTerminateRequest = false
-	response.Body.SupportsDataBreakpoints = false
+	response.Body.SupportsDataBreakpoints = true
 	response.Body.SupportsReadMemoryRequest = false
 	response.Body.SupportsDisassembleRequest = false
 	response.Body.SupportsCancelRequest = false
-	response.Body.SupportsBreakpointLocationsRequest = false
+	response.Body.SupportsBreakpointLocationsRequest = true
 
 	ds.send(response)
 
@@ -352,9 +551,92 @@ func (ds *JsonnetDebugSession) onInitializeRequest(request *dap.InitializeReques
 	ds.send(e)
 }
 
+// functionBreakpoint is a function breakpoint as requested by the client.
+// It is resolved to breakpoints on all matching definitions.
+type functionBreakpoint struct {
+	dap.FunctionBreakpoint
+	id int
+}
+
+// dataBreakpoint is a data breakpoint as requested by the client. It is
+// resolved to breakpoints on all definitions of the field.
+type dataBreakpoint struct {
+	dap.DataBreakpoint
+	id int
+}
+
 type launchRequest struct {
-	Program string   `json:"program"`
-	JPaths  []string `json:"jpaths"`
+	Program string `json:"program"`
+	// Code is evaluated instead of the program file, like with the -e flag.
+	Code    string            `json:"code"`
+	JPaths  []string          `json:"jpaths"`
+	ExtVars map[string]string `json:"extVars"`
+	ExtCode map[string]string `json:"extCode"`
+	TLAs    map[string]string `json:"tlas"`
+	TLACode map[string]string `json:"tlaCode"`
+	// RestartFrame enables restarting stack frames, which replays them
+	// rather than rewinding the evaluation. Finding where frames start
+	// slows the whole evaluation down, so it is disabled by default.
+	RestartFrame bool `json:"restartFrame"`
+}
+
+// configure passes the external variables and top-level arguments to the
+// VM evaluating the program.
+func (lr *launchRequest) configure(dbg *jsonnet.Debugger) error {
+	if len(lr.ExtVars)+len(lr.ExtCode)+len(lr.TLAs)+len(lr.TLACode) == 0 {
+		return nil
+	}
+	vm, err := debuggerVM(dbg)
+	if err != nil {
+		return err
+	}
+	for k, v := range lr.ExtVars {
+		vm.ExtVar(k, v)
+	}
+	for k, v := range lr.ExtCode {
+		vm.ExtCode(k, v)
+	}
+	for k, v := range lr.TLAs {
+		vm.TLAVar(k, v)
+	}
+	for k, v := range lr.TLACode {
+		vm.TLACode(k, v)
+	}
+	return nil
+}
+
+// external lists the external variables and top-level arguments as
+// read-only variables. Code is shown as written, it is only evaluated when
+// the program uses it.
+func (lr *launchRequest) external() []dap.Variable {
+	out := []dap.Variable{}
+	add := func(vars map[string]string, typ string, code bool, evaluateName func(string) string) {
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value := vars[name]
+			if !code {
+				value = quote(value)
+			}
+			out = append(out, dap.Variable{
+				Name:             name,
+				Value:            value,
+				Type:             typ,
+				EvaluateName:     evaluateName(name),
+				PresentationHint: &dap.VariablePresentationHint{Attributes: []string{"readOnly"}},
+			})
+		}
+	}
+	extVar := func(name string) string { return "std.extVar(" + quote(name) + ")" }
+	noEval := func(string) string { return "" }
+	add(lr.ExtVars, "ext var", false, extVar)
+	add(lr.ExtCode, "ext code", true, extVar)
+	add(lr.TLAs, "top-level argument", false, noEval)
+	add(lr.TLACode, "top-level code argument", true, noEval)
+	return out
 }
 
 func (ds *JsonnetDebugSession) onLaunchRequest(request *dap.LaunchRequest) {
@@ -365,13 +647,55 @@ func (ds *JsonnetDebugSession) onLaunchRequest(request *dap.LaunchRequest) {
 		ds.send(newErrorResponse(request.Seq, request.Command, "Invalid launch arguments"))
 		return
 	}
-	raw, err := os.ReadFile(lr.Program)
+	var raw []byte
+	jpaths := append([]string{}, lr.JPaths...)
+	if lr.Code != "" {
+		lr.Program = "<cmdline>"
+		raw = []byte(lr.Code)
+		ds.virtual.add(lr.Program, lr.Code)
+	} else {
+		raw, err = os.ReadFile(lr.Program)
+		if err != nil {
+			ds.send(newErrorResponse(request.Seq, request.Command, "Failed to open file: "+err.Error()))
+			return
+		}
+		// Like Debugger.Launch, the directory of the program takes
+		// precedence over the library paths
+		jpaths = append(jpaths, filepath.Dir(lr.Program))
+	}
+	program, err := resolveProgram(lr.Program, string(raw), lr.JPaths)
 	if err != nil {
-		ds.send(newErrorResponse(request.Seq, request.Command, "Failed to open file: "+err.Error()))
+		slog.Warn("unable to parse program", "err", err)
+	}
+	if err := lr.configure(ds.debugger); err != nil {
+		ds.send(newErrorResponse(request.Seq, request.Command, "Failed to set external variables: "+err.Error()))
 		return
 	}
-	ds.debugger.Launch(lr.Program, string(raw), lr.JPaths)
+	if err := ds.hooks.install(ds.debugger); err != nil {
+		slog.Warn("the evaluation cannot be paused", "err", err)
+	}
+	ds.launchMux.Lock()
+	ds.program = program
+	ds.launchArgs = lr
+	for _, b := range append(ds.setFunctionBreakpoints(), ds.setDataBreakpoints()...) {
+		ds.send(&dap.BreakpointEvent{
+			Event: *newEvent("breakpoint"),
+			Body:  dap.BreakpointEventBody{Reason: "changed", Breakpoint: b},
+		})
+	}
+	ds.launchMux.Unlock()
+	if lr.RestartFrame || ds.restartFrames {
+		if err := ds.restarter.install(&ds.hooks); err != nil {
+			slog.Warn("frames cannot be restarted", "err", err)
+		} else if !ds.restartFrames {
+			ds.send(&dap.CapabilitiesEvent{
+				Event: *newEvent("capabilities"),
+				Body:  dap.CapabilitiesEventBody{Capabilities: dap.Capabilities{SupportsRestartFrame: true}},
+			})
+		}
+	}
 	slog.Debug("Starting debugging", "breakpoints", ds.debugger.ActiveBreakpoints(), "file", lr.Program)
+	launchTracked(ds.debugger, lr.Program, string(raw), jpaths, ds.sourceLoaded)
 	response := &dap.LaunchResponse{}
 	response.Response = *newResponse(request.Seq, request.Command)
 	// We must wait for the configurationDone event before sending the response:
@@ -405,26 +729,258 @@ func (ds *JsonnetDebugSession) onSetBreakpointsRequest(request *dap.SetBreakpoin
 	response := &dap.SetBreakpointsResponse{}
 	response.Response = *newResponse(request.Seq, request.Command)
 	response.Body.Breakpoints = make([]dap.Breakpoint, len(request.Arguments.Breakpoints))
-	ds.debugger.ClearBreakpoints(request.Arguments.Source.Path)
+	file := request.Arguments.Source.Path
+	previous := ds.breakpoints.clear(ds.debugger, file)
 	for i, b := range request.Arguments.Breakpoints {
-		_, err := ds.debugger.SetBreakpoint(request.Arguments.Source.Path, b.Line, -1)
+		hitCond, err := parseBreakpointConditions(b.Condition, b.HitCondition)
 		if err != nil {
-			slog.Error("failed to set breakpoint", "err", err)
+			response.Body.Breakpoints[i] = dap.Breakpoint{
+				Id:      ds.breakpoints.newID(),
+				Message: err.Error(),
+				Source:  &dap.Source{Path: file},
+				Line:    b.Line,
+			}
 			continue
 		}
-		response.Body.Breakpoints[i].Line = b.Line
-		response.Body.Breakpoints[i].Verified = true
+		column := -1
+		if b.Column > 0 {
+			column = b.Column
+		}
+		bp := &breakpoint{
+			file:         file,
+			line:         b.Line,
+			column:       column,
+			condition:    b.Condition,
+			hitCondition: hitCond,
+			logMessage:   b.LogMessage,
+		}
+		if err := bp.place(ds.debugger, &ds.sources); err != nil {
+			slog.Debug("failed to set breakpoint", "file", file, "line", b.Line, "err", err)
+		}
+		if old, ok := previous[bp.key()]; ok {
+			bp.id = old.id
+			bp.hits = old.hits
+		} else {
+			bp.id = ds.breakpoints.newID()
+		}
+		ds.breakpoints.add(bp)
+		response.Body.Breakpoints[i] = dapBreakpoint(*bp)
 	}
 	ds.send(response)
 }
 
+// parseBreakpointConditions validates the condition and the hit condition
+// of a requested breakpoint.
+func parseBreakpointConditions(condition, hitCondition string) (*hitCondition, error) {
+	if condition != "" {
+		if err := checkExpression(condition); err != nil {
+			return nil, fmt.Errorf("Invalid condition: %w", err)
+		}
+	}
+	if hitCondition == "" {
+		return nil, nil
+	}
+	return parseHitCondition(hitCondition)
+}
+
+// dapBreakpoint converts a breakpoint, with the position of the expression
+// it was set on. The hit count is reported as part of the message, as DAP
+// has no dedicated field for it.
+func dapBreakpoint(bp breakpoint) dap.Breakpoint {
+	out := dap.Breakpoint{
+		Id:     bp.id,
+		Source: &dap.Source{Path: bp.file},
+		Line:   bp.line,
+		Column: max(bp.column, 0),
+	}
+	if bp.at == nil {
+		out.Message = bp.message
+		return out
+	}
+	out.Verified = true
+	out.Message = fmt.Sprintf("Hit %d times", bp.hits)
+	if bp.message != "" {
+		out.Message += ". " + bp.message
+	}
+	out.Line = bp.at.Begin.Line
+	out.Column = bp.at.Begin.Column
+	out.EndLine = bp.at.End.Line
+	out.EndColumn = bp.at.End.Column
+	return out
+}
+
 func (ds *JsonnetDebugSession) onSetFunctionBreakpointsRequest(request *dap.SetFunctionBreakpointsRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "SetFunctionBreakpointsRequest is not yet supported"))
+	ds.launchMux.Lock()
+	defer ds.launchMux.Unlock()
+	previous := map[string]int{}
+	for _, fb := range ds.functionBreakpoints {
+		previous[fb.Name] = fb.id
+	}
+	ds.functionBreakpoints = nil
+	for _, fb := range request.Arguments.Breakpoints {
+		id, ok := previous[fb.Name]
+		if !ok {
+			id = ds.breakpoints.newID()
+		}
+		ds.functionBreakpoints = append(ds.functionBreakpoints, functionBreakpoint{FunctionBreakpoint: fb, id: id})
+	}
+	response := &dap.SetFunctionBreakpointsResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	response.Body.Breakpoints = ds.setFunctionBreakpoints()
+	ds.send(response)
+}
+
+// setFunctionBreakpoints replaces the function breakpoints in the debugger
+// with the ones requested by the client. They stay unverified until the
+// program is launched, as resolving them requires parsing all its files.
+// Must be called with launchMux held.
+func (ds *JsonnetDebugSession) setFunctionBreakpoints() []dap.Breakpoint {
+	previous := map[string]int{}
+	for _, bp := range ds.breakpoints.remove(ds.debugger, func(bp *breakpoint) bool { return bp.function != "" }) {
+		previous[bp.function+"@"+bp.location] = bp.hits
+	}
+	out := make([]dap.Breakpoint, len(ds.functionBreakpoints))
+	for i, fb := range ds.functionBreakpoints {
+		out[i].Id = fb.id
+		hitCond, err := parseBreakpointConditions(fb.Condition, fb.HitCondition)
+		if err != nil {
+			out[i].Message = err.Error()
+			continue
+		}
+		if ds.program == nil {
+			out[i].Message = "Pending until the program is launched"
+			continue
+		}
+		defs := findFunctions(ds.program, fb.Name)
+		if len(defs) == 0 {
+			out[i].Message = fmt.Sprintf("No function named %s found", fb.Name)
+			continue
+		}
+		for _, def := range defs {
+			bp := &breakpoint{
+				id:           fb.id,
+				file:         def.file,
+				line:         def.at.Begin.Line,
+				column:       def.at.Begin.Column,
+				function:     fb.Name,
+				condition:    fb.Condition,
+				hitCondition: hitCond,
+			}
+			if err := ds.setDefinition(bp, def.file, def.at, def.literal); err != nil {
+				slog.Warn("failed to set function breakpoint", "function", def.name, "err", err)
+				if !out[i].Verified {
+					out[i].Message = err.Error()
+				}
+				continue
+			}
+			if def.call {
+				bp.message = stdCallNote
+			}
+			bp.hits = previous[fb.Name+"@"+bp.location]
+			ds.breakpoints.add(bp)
+			if !out[i].Verified {
+				out[i] = dapBreakpoint(*bp)
+			}
+		}
+	}
+	ds.breakAtLiterals()
+	return out
+}
+
+// setDefinition sets bp on the function body or field value at in file.
+// The debugger never stops at literals, the evaluation hooks stop at the
+// literal ones instead.
+func (ds *JsonnetDebugSession) setDefinition(bp *breakpoint, file string, at ast.LocationRange, literal bool) error {
+	if !literal {
+		return bp.set(ds.debugger, file)
+	}
+	if !ds.hooks.installed.Load() {
+		return fmt.Errorf("unsupported version of go-jsonnet: the evaluation hooks are not installed, the debugger cannot stop at literals")
+	}
+	bp.setLiteral(at)
+	return nil
+}
+
+// breakAtLiterals makes the evaluation hooks stop at the breakpoints set on
+// literals.
+func (ds *JsonnetDebugSession) breakAtLiterals() {
+	if err := ds.hooks.breakAtLiterals(ds.breakpoints.literals()); err != nil {
+		slog.Warn("unable to stop at breakpoints on literals", "err", err)
+	}
+}
+
+// exceptionBreakpointFilters are the exception filters offered to the
+// client. All of them accept a regular expression the error message has to
+// match as condition.
+var exceptionBreakpointFilters = []dap.ExceptionBreakpointsFilter{
+	{
+		Filter:               exceptionFilterError,
+		Label:                "Errors",
+		Description:          "Break where an `error` expression is evaluated",
+		Default:              true,
+		SupportsCondition:    true,
+		ConditionDescription: "Regular expression matching the error message",
+	},
+	{
+		Filter:               exceptionFilterAssert,
+		Label:                "Failed assertions",
+		Description:          "Break where an `assert` fails, in expressions and objects",
+		Default:              true,
+		SupportsCondition:    true,
+		ConditionDescription: "Regular expression matching the assertion message",
+	},
+	{
+		Filter:               exceptionFilterRuntime,
+		Label:                "Runtime errors",
+		Description:          "Break on type errors, missing fields, out of bounds indexes and errors raised by the standard library",
+		Default:              true,
+		SupportsCondition:    true,
+		ConditionDescription: "Regular expression matching the error message",
+	},
+	{
+		Filter:               exceptionFilterAll,
+		Label:                "All errors",
+		Description:          "Break on every error at every expression it propagates through, including errors that are later discarded",
+		SupportsCondition:    true,
+		ConditionDescription: "Regular expression matching the error message",
+	},
+}
+
+func findExceptionFilter(id string) *dap.ExceptionBreakpointsFilter {
+	for i, f := range exceptionBreakpointFilters {
+		if f.Filter == id {
+			return &exceptionBreakpointFilters[i]
+		}
+	}
+	return nil
 }
 
 func (ds *JsonnetDebugSession) onSetExceptionBreakpointsRequest(request *dap.SetExceptionBreakpointsRequest) {
 	response := &dap.SetExceptionBreakpointsResponse{}
 	response.Response = *newResponse(request.Seq, request.Command)
+	filters := map[string]*regexp.Regexp{}
+	options := []dap.ExceptionFilterOptions{}
+	for _, id := range request.Arguments.Filters {
+		options = append(options, dap.ExceptionFilterOptions{FilterId: id})
+	}
+	options = append(options, request.Arguments.FilterOptions...)
+	for _, opt := range options {
+		b := dap.Breakpoint{Verified: true}
+		if findExceptionFilter(opt.FilterId) == nil {
+			b = dap.Breakpoint{Message: fmt.Sprintf("Unknown exception filter %s", opt.FilterId)}
+		} else if opt.Condition != "" {
+			pattern, err := regexp.Compile(opt.Condition)
+			if err != nil {
+				b = dap.Breakpoint{Message: "Invalid condition: " + err.Error()}
+			} else {
+				filters[opt.FilterId] = pattern
+			}
+		} else {
+			filters[opt.FilterId] = nil
+		}
+		response.Body.Breakpoints = append(response.Body.Breakpoints, b)
+	}
+	ds.exceptions.set(filters)
 	ds.send(response)
 }
 
@@ -437,6 +993,7 @@ func (ds *JsonnetDebugSession) onConfigurationDoneRequest(request *dap.Configura
 }
 
 func (ds *JsonnetDebugSession) onContinueRequest(request *dap.ContinueRequest) {
+	ds.stepper.reset()
 	ds.debugger.Continue()
 	response := &dap.ContinueResponse{}
 	response.Response = *newResponse(request.Seq, request.Command)
@@ -444,6 +1001,7 @@ func (ds *JsonnetDebugSession) onContinueRequest(request *dap.ContinueRequest) {
 }
 
 func (ds *JsonnetDebugSession) onNextRequest(request *dap.NextRequest) {
+	ds.stepper.reset()
 	ds.debugger.ContinueUntilAfter(ds.current)
 	response := &dap.NextResponse{}
 	response.Response = *newResponse(request.Seq, request.Command)
@@ -451,14 +1009,28 @@ func (ds *JsonnetDebugSession) onNextRequest(request *dap.NextRequest) {
 }
 
 func (ds *JsonnetDebugSession) onStepInRequest(request *dap.StepInRequest) {
-	ds.debugger.Step()
+	if request.Arguments.TargetId > 0 {
+		if err := ds.stepper.stepInto(ds.debugger, &ds.hooks, request.Arguments.TargetId); err != nil {
+			ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
+			return
+		}
+	} else {
+		ds.stepper.reset()
+		ds.debugger.Step()
+	}
 	response := &dap.StepInResponse{}
 	response.Response = *newResponse(request.Seq, request.Command)
 	ds.send(response)
 }
 
 func (ds *JsonnetDebugSession) onStepOutRequest(request *dap.StepOutRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "StepOutRequest is not yet supported"))
+	if err := ds.stepper.stepOut(ds.debugger, &ds.hooks); err != nil {
+		ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
+		return
+	}
+	response := &dap.StepOutResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	ds.send(response)
 }
 
 func (ds *JsonnetDebugSession) onStepBackRequest(request *dap.StepBackRequest) {
@@ -469,35 +1041,77 @@ func (ds *JsonnetDebugSession) onReverseContinueRequest(request *dap.ReverseCont
 	ds.send(newErrorResponse(request.Seq, request.Command, "ReverseContinueRequest is not yet supported"))
 }
 
+// onRestartFrameRequest replays a stack frame, see replayNote.
 func (ds *JsonnetDebugSession) onRestartFrameRequest(request *dap.RestartFrameRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "RestartFrameRequest is not yet supported"))
+	ds.stepper.reset()
+	var err error
+	ds.frames.in(ds.debugger, -1, func([]jsonnet.TraceFrame) {
+		err = ds.restarter.restart(ds.debugger, frameIndex(request.Arguments.FrameId))
+	})
+	if err != nil {
+		ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
+		return
+	}
+	response := &dap.RestartFrameResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	ds.send(response)
 }
 
+// onGotoRequest runs to a target of the last goto targets request.
 func (ds *JsonnetDebugSession) onGotoRequest(request *dap.GotoRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "GotoRequest is not yet supported"))
+	file, at, err := ds.gotoTargets.get(request.Arguments.TargetId)
+	if err == nil {
+		bp := &breakpoint{file: file, line: at.Begin.Line, column: at.Begin.Column, temporary: true}
+		if err = bp.place(ds.debugger, &ds.sources); err == nil {
+			ds.breakpoints.add(bp)
+		}
+	}
+	if err != nil {
+		ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
+		return
+	}
+	ds.stepper.reset()
+	ds.debugger.Continue()
+	response := &dap.GotoResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	ds.send(response)
 }
 
 func (ds *JsonnetDebugSession) onPauseRequest(request *dap.PauseRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "PauseRequest is not yet supported"))
+	if err := ds.hooks.pause(); err != nil {
+		ds.send(newErrorResponse(request.Seq, request.Command, fmt.Sprintf("Unable to pause: %s", err)))
+		return
+	}
+	response := &dap.PauseResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	ds.send(response)
 }
 
 func (ds *JsonnetDebugSession) onStackTraceRequest(request *dap.StackTraceRequest) {
-	trace := ds.debugger.StackTrace()
+	// Other requests may truncate the stack while inspecting a frame
+	var trace []jsonnet.TraceFrame
+	var restartable []bool
+	ds.frames.in(ds.debugger, -1, func(t []jsonnet.TraceFrame) {
+		trace = t
+		for i := range trace {
+			restartable = append(restartable, ds.restarter.canRestart(ds.debugger, i))
+		}
+	})
 	response := &dap.StackTraceResponse{}
 	response.Response = *newResponse(request.Seq, request.Command)
 	frames := []dap.StackFrame{}
 	for i, frame := range trace {
 		fr := dap.StackFrame{
-			Id:   i,
-			Name: frame.Name,
+			Id:         i + 1,
+			Name:       frame.Name,
+			CanRestart: restartable[i],
 		}
 		if frame.Loc.File != nil {
-			abs, err := filepath.Abs(string(frame.Loc.File.DiagnosticFileName))
-			if err != nil {
+			fr.Source = ds.source(string(frame.Loc.File.DiagnosticFileName), frame.Loc.File)
+			if fr.Source == nil {
 				slog.Error("invalid location for stack frame")
 				continue
 			}
-			fr.Source = &dap.Source{Name: string(frame.Loc.File.DiagnosticFileName), Path: abs, SourceReference: 0}
 			fr.Line = frame.Loc.Begin.Line
 			fr.Column = frame.Loc.Begin.Column
 			fr.EndLine = frame.Loc.End.Line
@@ -515,43 +1129,139 @@ func (ds *JsonnetDebugSession) onStackTraceRequest(request *dap.StackTraceReques
 	ds.send(response)
 }
 
+// frameIndex converts a DAP frame id to an index into stackTrace.
+// Frame ids start at 1, so requests without a frame id refer to the
+// innermost frame.
+func frameIndex(frameID int) int {
+	return frameID - 1
+}
+
+// enclosing returns the nodes enclosing the position of frame, innermost
+// first.
+func (ds *JsonnetDebugSession) enclosing(program []*programFile, trace []jsonnet.TraceFrame, frame int) []ast.Node {
+	if frame < 0 || frame >= len(trace)-1 {
+		return ancestors(program, ds.current.Loc(), reflect.TypeOf(ds.current))
+	}
+	return ancestors(program, &trace[frame].Loc, nil)
+}
+
 func (ds *JsonnetDebugSession) onScopesRequest(request *dap.ScopesRequest) {
+	ds.launchMux.Lock()
+	program := ds.program
+	external := ds.launchArgs.external()
+	ds.launchMux.Unlock()
+
+	frame := frameIndex(request.Arguments.FrameId)
+	scopes := []dap.Scope{}
+	err := ds.frames.in(ds.debugger, frame, func(trace []jsonnet.TraceFrame) {
+		fv := classifyVariables(ds.enclosing(program, trace, frame), ds.debugger.ListVars())
+		if len(fv.arguments) > 0 {
+			scopes = append(scopes, dap.Scope{
+				Name:               "Arguments",
+				PresentationHint:   "arguments",
+				VariablesReference: ds.variables.create(&scopeVariables{names: fv.arguments, frame: frame}),
+				NamedVariables:     len(fv.arguments),
+			})
+		}
+		innermost := frame < 0 || frame == len(trace)-1
+		scopes = append(scopes, dap.Scope{
+			Name:               "Locals",
+			PresentationHint:   "locals",
+			VariablesReference: ds.variables.create(&scopeVariables{names: fv.locals, frame: frame, returnValue: innermost}),
+			NamedVariables:     len(fv.locals),
+		})
+		if len(fv.closure) > 0 {
+			scopes = append(scopes, dap.Scope{
+				Name:               "Closure",
+				VariablesReference: ds.variables.create(&scopeVariables{names: fv.closure, frame: frame}),
+				NamedVariables:     len(fv.closure),
+			})
+		}
+		// self, super and $ only have a scope where they are bound
+		if self, err := lookupValue(ds.debugger, "self"); err == nil {
+			scopes = append(scopes, ds.objectScope("self", frame, self))
+		}
+		if fields, err := superFields(ds.debugger); err != nil {
+			slog.Warn("unable to list the fields of super", "err", err)
+		} else if len(fields) > 0 {
+			scopes = append(scopes, dap.Scope{
+				Name:               "super",
+				VariablesReference: ds.variables.create(&superScope{fields: fields, frame: frame}),
+				NamedVariables:     len(fields),
+			})
+		}
+		if dollar, err := lookupValue(ds.debugger, "$"); err == nil {
+			scopes = append(scopes, ds.objectScope("$", frame, dollar))
+		}
+	})
+	if err != nil {
+		ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
+		return
+	}
+	if len(external) > 0 {
+		scopes = append(scopes, dap.Scope{
+			Name:               "External",
+			VariablesReference: ds.variables.create(externalScope{}),
+			NamedVariables:     len(external),
+		})
+	}
+
 	response := &dap.ScopesResponse{}
 	response.Response = *newResponse(request.Seq, request.Command)
-	response.Body = dap.ScopesResponseBody{
-		Scopes: []dap.Scope{
-			{Name: "Local", VariablesReference: 1000, Expensive: false},
-		},
-	}
+	response.Body = dap.ScopesResponseBody{Scopes: scopes}
 	ds.send(response)
 }
 
-func (ds *JsonnetDebugSession) onVariablesRequest(request *dap.VariablesRequest) {
-	vars := ds.debugger.ListVars()
-	selfPresent := false
-	for _, v := range vars {
-		if v == "self" {
-			selfPresent = true
-		}
+// objectScope is the scope listing the fields of obj, the value of the
+// expression name in the stack frame frame.
+func (ds *JsonnetDebugSession) objectScope(name string, frame int, obj *debugValue) dap.Scope {
+	return dap.Scope{
+		Name:               name,
+		VariablesReference: ds.variables.create(&valueContainer{value: obj, evaluateName: name, frame: frame}),
+		NamedVariables:     obj.size,
 	}
-	if !selfPresent {
-		vars = append(vars, "self")
+}
+
+func (ds *JsonnetDebugSession) onVariablesRequest(request *dap.VariablesRequest) {
+	handle, ok := ds.variables.get(request.Arguments.VariablesReference)
+	if !ok {
+		ds.send(newErrorResponse(request.Seq, request.Command, "Invalid variables reference, the debugger resumed since it was created"))
+		return
 	}
 	out := []dap.Variable{}
-	for _, v := range vars {
-		val, err := ds.debugger.LookupValue(string(v))
+	switch h := handle.(type) {
+	case *scopeVariables:
+		err := ds.frames.in(ds.debugger, h.frame, func([]jsonnet.TraceFrame) {
+			out = ds.scopeVariables(h)
+		})
 		if err != nil {
-			slog.Warn("Failed to get value for variable listing", "var", v, "err", err)
-			val = ""
+			ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
+			return
 		}
-		if string(v) == "self" {
-			selfPresent = true
+	case *superScope:
+		err := ds.frames.in(ds.debugger, h.frame, func([]jsonnet.TraceFrame) {
+			out = ds.variables.superChildren(ds.debugger, h, request.Arguments)
+		})
+		if err != nil {
+			ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
+			return
 		}
-		out = append(out, dap.Variable{
-			Name:         string(v),
-			Value:        val,
-			EvaluateName: string(v),
+	case externalScope:
+		ds.launchMux.Lock()
+		out = ds.launchArgs.external()
+		ds.launchMux.Unlock()
+	case *valueContainer:
+		var err error
+		ferr := ds.frames.in(ds.debugger, h.frame, func([]jsonnet.TraceFrame) {
+			out, err = ds.variables.children(ds.debugger, h, request.Arguments)
 		})
+		if ferr != nil {
+			err = ferr
+		}
+		if err != nil {
+			ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
+			return
+		}
 	}
 	response := &dap.VariablesResponse{}
 	response.Response = *newResponse(request.Seq, request.Command)
@@ -561,17 +1271,143 @@ func (ds *JsonnetDebugSession) onVariablesRequest(request *dap.VariablesRequest)
 	ds.send(response)
 }
 
+// scopeVariables looks up the values of the variables of a scope.
+func (ds *JsonnetDebugSession) scopeVariables(scope *scopeVariables) []dap.Variable {
+	out := []dap.Variable{}
+	if returned, ok := ds.stepper.returnValue(); ok && scope.returnValue {
+		out = append(out, ds.variables.variable(scope.frame, "(return value)", "", returned))
+	}
+	for _, name := range scope.names {
+		val, err := lookupValue(ds.debugger, name)
+		if err != nil {
+			slog.Warn("Failed to get value for variable listing", "var", name, "err", err)
+			out = append(out, dap.Variable{Name: name, Value: err.Error(), EvaluateName: name})
+			continue
+		}
+		out = append(out, ds.variables.variable(scope.frame, name, name, val))
+	}
+	return out
+}
+
+// onSetVariableRequest replaces a local, or a field or element of a value
+// shown in the variables view, with the result of a Jsonnet expression.
 func (ds *JsonnetDebugSession) onSetVariableRequest(request *dap.SetVariableRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "setVariableRequest is not yet supported"))
+	args := request.Arguments
+	handle, ok := ds.variables.get(args.VariablesReference)
+	if !ok {
+		ds.send(newErrorResponse(request.Seq, request.Command, "Invalid variables reference, the debugger resumed since it was created"))
+		return
+	}
+	var frame int
+	var target string
+	switch h := handle.(type) {
+	case *scopeVariables:
+		// Only locals can be set, not the return value of the frame
+		frame = h.frame
+		if identifierPattern.MatchString(args.Name) {
+			target = args.Name
+		}
+	case *valueContainer:
+		frame = h.frame
+		if h.value.kind == valueKindArray {
+			if h.evaluateName != "" {
+				target = indexable(h.evaluateName) + args.Name
+			}
+		} else {
+			target = fieldExpression(h.evaluateName, args.Name)
+		}
+	}
+	if target == "" {
+		ds.send(newErrorResponse(request.Seq, request.Command, fmt.Sprintf("%s cannot be set", args.Name)))
+		return
+	}
+	variable, err := ds.assign(frame, args.Name, target, args.Value)
+	if err != nil {
+		ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
+		return
+	}
+	response := &dap.SetVariableResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	response.Body = dap.SetVariableResponseBody{
+		Value:              variable.Value,
+		Type:               variable.Type,
+		VariablesReference: variable.VariablesReference,
+		NamedVariables:     variable.NamedVariables,
+		IndexedVariables:   variable.IndexedVariables,
+	}
+	ds.send(response)
+	ds.invalidateVariables()
 }
 
+// onSetExpressionRequest assigns to a variable, field or element written as
+// an expression, such as `deployment.spec.replicas`.
 func (ds *JsonnetDebugSession) onSetExpressionRequest(request *dap.SetExpressionRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "SetExpressionRequest is not yet supported"))
+	args := request.Arguments
+	variable, err := ds.assign(frameIndex(args.FrameId), args.Expression, args.Expression, args.Value)
+	if err != nil {
+		ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
+		return
+	}
+	response := &dap.SetExpressionResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	response.Body = dap.SetExpressionResponseBody{
+		Value:              variable.Value,
+		Type:               variable.Type,
+		VariablesReference: variable.VariablesReference,
+		NamedVariables:     variable.NamedVariables,
+		IndexedVariables:   variable.IndexedVariables,
+	}
+	ds.send(response)
+	ds.invalidateVariables()
+}
+
+// assign sets target to the value of expr in the environment of frame, and
+// returns the new value as variable name.
+func (ds *JsonnetDebugSession) assign(frame int, name, target, expr string) (dap.Variable, error) {
+	var variable dap.Variable
+	var err error
+	ferr := ds.frames.in(ds.debugger, frame, func([]jsonnet.TraceFrame) {
+		if err = assign(ds.debugger, target, expr); err != nil {
+			return
+		}
+		var v *debugValue
+		if v, err = evaluate(ds.debugger, target); err == nil {
+			variable = ds.variables.variable(frame, name, target, v)
+		}
+	})
+	if ferr != nil {
+		err = ferr
+	}
+	return variable, err
+}
+
+// invalidateVariables makes the client fetch the variables again after one
+// of them changed, as the change may show in others.
+func (ds *JsonnetDebugSession) invalidateVariables() {
+	if !ds.invalidatedEvents.Load() {
+		return
+	}
+	ds.send(&dap.InvalidatedEvent{
+		Event: *newEvent("invalidated"),
+		Body:  dap.InvalidatedEventBody{Areas: []dap.InvalidatedAreas{"variables"}},
+	})
 }
 
 func (ds *JsonnetDebugSession) onSourceRequest(request *dap.SourceRequest) {
-	slog.Debug("source requested", "source", request.Arguments.Source.SourceReference)
-	ds.send(newErrorResponse(request.Seq, request.Command, "SourceRequest is not yet supported"))
+	ref := request.Arguments.SourceReference
+	if request.Arguments.Source != nil && request.Arguments.Source.SourceReference != 0 {
+		ref = request.Arguments.Source.SourceReference
+	}
+	slog.Debug("source requested", "source", ref)
+	contents, ok := ds.virtual.get(ref)
+	if !ok {
+		ds.send(newErrorResponse(request.Seq, request.Command, fmt.Sprintf("Unknown source reference %d", ref)))
+		return
+	}
+	response := &dap.SourceResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	response.Body = dap.SourceResponseBody{Content: contents, MimeType: "text/x-jsonnet"}
+	ds.send(response)
 }
 
 func (ds *JsonnetDebugSession) onThreadsRequest(request *dap.ThreadsRequest) {
@@ -587,46 +1423,422 @@ func (ds *JsonnetDebugSession) onTerminateThreadsRequest(request *dap.TerminateT
 }
 
 func (ds *JsonnetDebugSession) onEvaluateRequest(request *dap.EvaluateRequest) {
-	v, err := ds.debugger.LookupValue(request.Arguments.Expression)
+	expr := request.Arguments.Expression
+	if request.Arguments.Context == "hover" {
+		ds.onHover(request)
+		return
+	}
+	var v *debugValue
+	var err error
+	ferr := ds.frames.in(ds.debugger, frameIndex(request.Arguments.FrameId), func([]jsonnet.TraceFrame) {
+		v, err = evaluate(ds.debugger, expr)
+	})
+	if ferr != nil {
+		err = ferr
+	}
 	if err != nil {
-		ds.send(newErrorResponse(request.Seq, request.Command, fmt.Sprintf("Failed to look up variable: %s", err.Error())))
+		ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
 		return
 	}
+	variable := ds.variables.variable(frameIndex(request.Arguments.FrameId), expr, expr, v)
 	response := &dap.EvaluateResponse{}
 	response.Response = *newResponse(request.Seq, request.Command)
 	response.Body = dap.EvaluateResponseBody{
-		Result: v,
-		Type:   "string",
+		Result:             variable.Value,
+		Type:               variable.Type,
+		VariablesReference: variable.VariablesReference,
+		NamedVariables:     variable.NamedVariables,
+		IndexedVariables:   variable.IndexedVariables,
 	}
 	ds.send(response)
 }
 
+// onHover answers evaluate requests made by hovering over expr in the
+// editor. Failed lookups give an empty result, as errors would be shown in a
+// popup.
+func (ds *JsonnetDebugSession) onHover(request *dap.EvaluateRequest) {
+	expr := request.Arguments.Expression
+	var v *debugValue
+	ok := false
+	err := ds.frames.in(ds.debugger, frameIndex(request.Arguments.FrameId), func([]jsonnet.TraceFrame) {
+		v, ok = hover(ds.debugger, expr)
+	})
+	response := &dap.EvaluateResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	if err == nil && ok {
+		variable := ds.variables.cachedVariable(frameIndex(request.Arguments.FrameId), expr, expr, v)
+		response.Body = dap.EvaluateResponseBody{
+			Result:             variable.Value,
+			Type:               variable.Type,
+			VariablesReference: variable.VariablesReference,
+			NamedVariables:     variable.NamedVariables,
+			IndexedVariables:   variable.IndexedVariables,
+		}
+	}
+	ds.send(response)
+}
+
+// onStepInTargetsRequest lists the sub-expressions of the expression the
+// debugger is stopped at, so the user can pick which one to step into
+// instead of the one evaluated first.
 func (ds *JsonnetDebugSession) onStepInTargetsRequest(request *dap.StepInTargetsRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "StepInTargetRequest is not yet supported"))
+	targets := []dap.StepInTarget{}
+	depth := 0
+	ds.frames.in(ds.debugger, -1, func(trace []jsonnet.TraceFrame) { depth = len(trace) })
+	// Only the innermost frame can be stepped into
+	if frameIndex(request.Arguments.FrameId) == depth-1 && ds.current != nil {
+		for i, node := range ds.stepper.stepInTargets(ds.current) {
+			loc := node.Loc()
+			targets = append(targets, dap.StepInTarget{
+				Id:        i + 1,
+				Label:     sourceLabel(loc),
+				Line:      loc.Begin.Line,
+				Column:    loc.Begin.Column,
+				EndLine:   loc.End.Line,
+				EndColumn: loc.End.Column,
+			})
+		}
+	}
+	response := &dap.StepInTargetsResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	response.Body = dap.StepInTargetsResponseBody{Targets: targets}
+	ds.send(response)
+}
+
+// sourceLabel shows the source code at loc on a single line, cut off like
+// value previews.
+func sourceLabel(loc *ast.LocationRange) string {
+	label := strings.Join(strings.Fields(locationSource(loc)), " ")
+	if r := []rune(label); len(r) > maxValuePreview {
+		label = string(r[:maxValuePreview]) + "…"
+	}
+	return label
 }
 
+// onGotoTargetsRequest lists the expressions starting on a line to run to.
 func (ds *JsonnetDebugSession) onGotoTargetsRequest(request *dap.GotoTargetsRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "GotoTargetRequest is not yet supported"))
+	args := request.Arguments
+	locations, err := lineTargets(args.Source.Path, args.Line, args.Column)
+	if err != nil {
+		ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
+		return
+	}
+	ds.gotoTargets.set(args.Source.Path, locations)
+	targets := []dap.GotoTarget{}
+	for i, loc := range locations {
+		targets = append(targets, dap.GotoTarget{
+			Id:        i + 1,
+			Label:     sourceLabel(loc),
+			Line:      loc.Begin.Line,
+			Column:    loc.Begin.Column,
+			EndLine:   loc.End.Line,
+			EndColumn: loc.End.Column,
+		})
+	}
+	response := &dap.GotoTargetsResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	response.Body = dap.GotoTargetsResponseBody{Targets: targets}
+	ds.send(response)
 }
 
 func (ds *JsonnetDebugSession) onCompletionsRequest(request *dap.CompletionsRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "CompletionRequest is not yet supported"))
+	lines := strings.Split(request.Arguments.Text, "\n")
+	line := lines[0]
+	if request.Arguments.Line > 0 && request.Arguments.Line <= len(lines) {
+		line = lines[request.Arguments.Line-1]
+	}
+	// Columns count UTF-16 code units
+	units := utf16.Encode([]rune(line))
+	column := min(max(request.Arguments.Column-1, 0), len(units))
+	text := string(utf16.Decode(units[:column]))
+
+	var prefix string
+	var candidates []completion
+	err := ds.frames.in(ds.debugger, frameIndex(request.Arguments.FrameId), func([]jsonnet.TraceFrame) {
+		prefix, candidates = complete(ds.debugger, text)
+	})
+	if err != nil {
+		ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
+		return
+	}
+	length := len(utf16.Encode([]rune(prefix)))
+	targets := []dap.CompletionItem{}
+	for _, c := range candidates {
+		targets = append(targets, dap.CompletionItem{
+			Label:  c.label,
+			Text:   c.label,
+			Detail: c.detail,
+			Type:   dap.CompletionItemType(c.kind),
+			Length: length,
+		})
+	}
+	response := &dap.CompletionsResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	response.Body = dap.CompletionsResponseBody{Targets: targets}
+	ds.send(response)
 }
 
 func (ds *JsonnetDebugSession) onExceptionInfoRequest(request *dap.ExceptionInfoRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "ExceptionRequest is not yet supported"))
+	ev := ds.exceptions.current()
+	if ev == nil {
+		ds.send(newErrorResponse(request.Seq, request.Command, "Not stopped at an exception"))
+		return
+	}
+	kind := classifyException(ev.Current)
+	msg := exceptionMessage(ev.Error)
+	details := &dap.ExceptionDetails{
+		Message:    msg,
+		TypeName:   kind,
+		StackTrace: ev.ErrorFmt(),
+	}
+	err := ds.frames.in(ds.debugger, -1, func([]jsonnet.TraceFrame) {
+		expr, v, ok := offendingValue(ds.debugger, ev.Current, ev.Error)
+		if !ok {
+			return
+		}
+		details.EvaluateName = expr
+		value := valuePreview(v)
+		if value != expr {
+			value = expr + " = " + value
+		}
+		msg += fmt.Sprintf("\n%s (%s)", value, v.kind)
+		details.Message = msg
+	})
+	if err != nil {
+		ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
+		return
+	}
+	response := &dap.ExceptionInfoResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	response.Body = dap.ExceptionInfoResponseBody{
+		ExceptionId: kind,
+		Description: msg,
+		// Errors cannot be caught in Jsonnet
+		BreakMode: dap.ExceptionBreakMode("unhandled"),
+		Details:   details,
+	}
+	ds.send(response)
 }
 
 func (ds *JsonnetDebugSession) onLoadedSourcesRequest(request *dap.LoadedSourcesRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "LoadedRequest is not yet supported"))
+	sources := []dap.Source{}
+	for _, s := range ds.sources.list() {
+		if src := ds.loadedSource(s); src != nil {
+			sources = append(sources, *src)
+		}
+	}
+	response := &dap.LoadedSourcesResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	response.Body = dap.LoadedSourcesResponseBody{Sources: sources}
+	ds.send(response)
+}
+
+// sourceLoaded notifies the client of files loaded for the first time, and
+// of the pending breakpoints in them that could be set.
+func (ds *JsonnetDebugSession) sourceLoaded(s loadedSource) {
+	if !ds.sources.add(s) {
+		return
+	}
+	for _, bp := range ds.breakpoints.resolve(ds.debugger, s.path) {
+		ds.send(&dap.BreakpointEvent{
+			Event: *newEvent("breakpoint"),
+			Body:  dap.BreakpointEventBody{Reason: "changed", Breakpoint: dapBreakpoint(bp)},
+		})
+	}
+	src := ds.loadedSource(s)
+	if src == nil {
+		return
+	}
+	ds.send(&dap.LoadedSourceEvent{
+		Event: *newEvent("loadedSource"),
+		Body:  dap.LoadedSourceEventBody{Reason: "new", Source: *src},
+	})
 }
 
+// loadedSource describes a loaded file to the client, with the library path
+// it was found in as its origin.
+func (ds *JsonnetDebugSession) loadedSource(s loadedSource) *dap.Source {
+	src := ds.source(s.path, nil)
+	if src != nil && s.jpath != "" {
+		src.Origin = "jpath " + s.jpath
+	}
+	return src
+}
+
+// source describes the source name to the client. Sources without a file
+// on disk are given a reference to fetch their contents with, from file if
+// it is not nil. It returns nil if the source cannot be shown.
+func (ds *JsonnetDebugSession) source(name string, file *ast.Source) *dap.Source {
+	if !isVirtual(name) {
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return nil
+		}
+		return &dap.Source{Name: name, Path: abs}
+	}
+	src := &dap.Source{Name: name, SourceReference: ds.virtual.reference(name, file)}
+	if name == "<std>" {
+		src.Origin = "standard library"
+		src.PresentationHint = "deemphasize"
+	}
+	return src
+}
+
+// onDataBreakpointInfoRequest offers object fields as data breakpoint
+// targets, which stop when the field is evaluated. Fields are picked from
+// the variables view or written as expressions such as
+// `deployment.spec.replicas`, a plain name watches the field of that name in
+// every object.
 func (ds *JsonnetDebugSession) onDataBreakpointInfoRequest(request *dap.DataBreakpointInfoRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "DataBreakpointInfoRequest is not yet supported"))
+	args := request.Arguments
+	response := &dap.DataBreakpointInfoResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	frame := frameIndex(args.FrameId)
+	expr := args.Name
+	if args.VariablesReference != 0 {
+		handle, _ := ds.variables.get(args.VariablesReference)
+		c, ok := handle.(*valueContainer)
+		if !ok || c.value.kind != valueKindObject {
+			response.Body.Description = "Only object fields can be watched"
+			ds.send(response)
+			return
+		}
+		frame = c.frame
+		expr = fieldExpression(c.evaluateName, args.Name)
+		if expr == "" {
+			// The object cannot be found again, e.g. a return value
+			expr = args.Name
+		}
+	}
+	node, field, err := splitField(expr)
+	if err != nil {
+		response.Body.Description = err.Error()
+		ds.send(response)
+		return
+	}
+	if node == nil {
+		response.Body.DataId = dataID(-1, field)
+		response.Body.Description = fmt.Sprintf("Field %s of any object", field)
+		response.Body.CanPersist = true
+		ds.send(response)
+		return
+	}
+	var obj reflect.Value
+	ferr := ds.frames.in(ds.debugger, frame, func([]jsonnet.TraceFrame) {
+		obj, err = watchedObject(ds.debugger, node)
+	})
+	if ferr != nil {
+		err = ferr
+	}
+	if err != nil {
+		response.Body.Description = err.Error()
+		ds.send(response)
+		return
+	}
+	response.Body.DataId = dataID(ds.watch(obj), field)
+	response.Body.Description = expr
+	ds.send(response)
+}
+
+// watch returns the index of obj in the watched objects, adding it if
+// needed.
+func (ds *JsonnetDebugSession) watch(obj reflect.Value) int {
+	ds.launchMux.Lock()
+	defer ds.launchMux.Unlock()
+	for i, w := range ds.watched {
+		if w.Pointer() == obj.Pointer() {
+			return i
+		}
+	}
+	ds.watched = append(ds.watched, obj)
+	return len(ds.watched) - 1
 }
 
 func (ds *JsonnetDebugSession) onSetDataBreakpointsRequest(request *dap.SetDataBreakpointsRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "SetDataBreakpointsRequest is not yet supported"))
+	ds.launchMux.Lock()
+	defer ds.launchMux.Unlock()
+	previous := map[string]int{}
+	for _, db := range ds.dataBreakpoints {
+		previous[db.DataId] = db.id
+	}
+	ds.dataBreakpoints = nil
+	for _, db := range request.Arguments.Breakpoints {
+		id, ok := previous[db.DataId]
+		if !ok {
+			id = ds.breakpoints.newID()
+		}
+		ds.dataBreakpoints = append(ds.dataBreakpoints, dataBreakpoint{DataBreakpoint: db, id: id})
+	}
+	response := &dap.SetDataBreakpointsResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	response.Body.Breakpoints = ds.setDataBreakpoints()
+	ds.send(response)
+}
+
+// setDataBreakpoints replaces the data breakpoints in the debugger with the
+// ones requested by the client. Like function breakpoints, they stay
+// unverified until the program is launched. Must be called with launchMux
+// held.
+func (ds *JsonnetDebugSession) setDataBreakpoints() []dap.Breakpoint {
+	previous := map[string]int{}
+	for _, bp := range ds.breakpoints.remove(ds.debugger, func(bp *breakpoint) bool { return bp.field != "" }) {
+		previous[fmt.Sprintf("%d@%s", bp.id, bp.location)] = bp.hits
+	}
+	out := make([]dap.Breakpoint, len(ds.dataBreakpoints))
+	for i, db := range ds.dataBreakpoints {
+		out[i].Id = db.id
+		hitCond, err := parseBreakpointConditions(db.Condition, db.HitCondition)
+		if err != nil {
+			out[i].Message = err.Error()
+			continue
+		}
+		object, field, err := parseDataID(db.DataId)
+		if err == nil && object >= len(ds.watched) {
+			err = fmt.Errorf("Unknown data breakpoint %q", db.DataId)
+		}
+		if err != nil {
+			out[i].Message = err.Error()
+			continue
+		}
+		if ds.program == nil {
+			out[i].Message = "Pending until the program is launched"
+			continue
+		}
+		defs := findFields(ds.program, field)
+		if len(defs) == 0 {
+			out[i].Message = fmt.Sprintf("No field named %s found", field)
+			continue
+		}
+		for _, def := range defs {
+			bp := &breakpoint{
+				id:           db.id,
+				file:         def.file,
+				line:         def.at.Begin.Line,
+				column:       def.at.Begin.Column,
+				field:        field,
+				layer:        &def.object,
+				condition:    db.Condition,
+				hitCondition: hitCond,
+			}
+			if object >= 0 {
+				bp.object = ds.watched[object]
+			}
+			if err := ds.setDefinition(bp, def.file, def.at, def.literal); err != nil {
+				slog.Warn("failed to set data breakpoint", "field", field, "err", err)
+				if !out[i].Verified {
+					out[i].Message = err.Error()
+				}
+				continue
+			}
+			bp.hits = previous[fmt.Sprintf("%d@%s", bp.id, bp.location)]
+			ds.breakpoints.add(bp)
+			if !out[i].Verified {
+				out[i] = dapBreakpoint(*bp)
+			}
+		}
+	}
+	ds.breakAtLiterals()
+	return out
 }
 
 func (ds *JsonnetDebugSession) onReadMemoryRequest(request *dap.ReadMemoryRequest) {
@@ -642,7 +1854,48 @@ func (ds *JsonnetDebugSession) onCancelRequest(request *dap.CancelRequest) {
 }
 
 func (ds *JsonnetDebugSession) onBreakpointLocationsRequest(request *dap.BreakpointLocationsRequest) {
-	ds.send(newErrorResponse(request.Seq, request.Command, "BreakpointLocationsRequest is not yet supported"))
+	args := request.Arguments
+	locations, err := stopLocations(args.Source.Path)
+	if err != nil {
+		ds.send(newErrorResponse(request.Seq, request.Command, err.Error()))
+		return
+	}
+	endLine := args.EndLine
+	if endLine < args.Line {
+		endLine = args.Line
+	}
+	inRange := func(l *ast.LocationRange) bool {
+		begin := l.Begin
+		if begin.Line < args.Line || begin.Line > endLine {
+			return false
+		}
+		if begin.Line == args.Line && args.Column > 0 && begin.Column < args.Column {
+			return false
+		}
+		return begin.Line != endLine || args.EndColumn <= 0 || begin.Column < args.EndColumn
+	}
+	breakpoints := []dap.BreakpointLocation{}
+	for _, l := range locations {
+		if !inRange(l) {
+			continue
+		}
+		breakpoints = append(breakpoints, dap.BreakpointLocation{
+			Line:      l.Begin.Line,
+			Column:    l.Begin.Column,
+			EndLine:   l.End.Line,
+			EndColumn: l.End.Column,
+		})
+	}
+	sort.Slice(breakpoints, func(i, j int) bool {
+		if breakpoints[i].Line != breakpoints[j].Line {
+			return breakpoints[i].Line < breakpoints[j].Line
+		}
+		return breakpoints[i].Column < breakpoints[j].Column
+	})
+	response := &dap.BreakpointLocationsResponse{}
+	response.Response = *newResponse(request.Seq, request.Command)
+	response.Body = dap.BreakpointLocationsResponseBody{Breakpoints: breakpoints}
+	ds.send(response)
 }
 
 func newEvent(event string) *dap.Event {
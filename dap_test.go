@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-dap"
+)
+
+// testClient drives a debug session over an in-memory connection, the way
+// an editor does.
+type testClient struct {
+	t        *testing.T
+	conn     net.Conn
+	seq      int
+	messages chan dap.Message
+	// pending are the messages received while waiting for others.
+	pending []dap.Message
+}
+
+func startSession(t *testing.T) *testClient {
+	t.Helper()
+	server, conn := net.Pipe()
+	go handleConnection(server, false)
+	c := &testClient{t: t, conn: conn, messages: make(chan dap.Message, 1024)}
+	go func() {
+		defer close(c.messages)
+		r := bufio.NewReader(conn)
+		for {
+			msg, err := dap.ReadProtocolMessage(r)
+			if err != nil {
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+	t.Cleanup(func() { conn.Close() })
+	return c
+}
+
+// send sends the request command with args, which are encoded as JSON.
+func (c *testClient) send(command string, args any) {
+	c.t.Helper()
+	c.seq++
+	msg := map[string]any{"seq": c.seq, "type": "request", "command": command}
+	if args != nil {
+		msg["arguments"] = args
+	}
+	content, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := dap.WriteBaseMessage(c.conn, content); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// await returns the first message of type T, keeping the others for later.
+// Error responses fail the test, unless T is *dap.ErrorResponse.
+func await[T dap.Message](c *testClient) T {
+	c.t.Helper()
+	for i, msg := range c.pending {
+		if m, ok := msg.(T); ok {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return m
+		}
+	}
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				c.t.Fatalf("the connection was closed waiting for a %T", *new(T))
+			}
+			if m, ok := msg.(T); ok {
+				return m
+			}
+			if e, ok := msg.(*dap.ErrorResponse); ok {
+				c.t.Fatalf("%s failed: %s", e.Command, e.Body.Error.Format)
+			}
+			c.pending = append(c.pending, msg)
+		case <-timeout:
+			c.t.Fatalf("timed out waiting for a %T", *new(T))
+		}
+	}
+}
+
+// launchSession starts a session debugging src, calling configure with the
+// path of the program before the configuration is done, e.g. to set
+// breakpoints.
+func launchSession(t *testing.T, src string, configure func(c *testClient, path string)) *testClient {
+	t.Helper()
+	path := writeFile(t, src)
+	return launchWith(t, map[string]any{"program": path}, func(c *testClient) {
+		if configure != nil {
+			configure(c, path)
+		}
+	})
+}
+
+// launchWith starts a session launched with args, calling configure before
+// the configuration is done.
+func launchWith(t *testing.T, args map[string]any, configure func(c *testClient)) *testClient {
+	t.Helper()
+	c := startSession(t)
+	c.send("initialize", map[string]any{"adapterID": "jsonnet"})
+	await[*dap.InitializeResponse](c)
+	if configure != nil {
+		configure(c)
+	}
+	c.send("configurationDone", nil)
+	await[*dap.ConfigurationDoneResponse](c)
+	c.send("launch", args)
+	await[*dap.LaunchResponse](c)
+	return c
+}
+
+// setBreakpoints sets breakpoints in path, they must all be verified.
+func (c *testClient) setBreakpoints(path string, breakpoints ...dap.SourceBreakpoint) {
+	c.t.Helper()
+	c.send("setBreakpoints", dap.SetBreakpointsArguments{Source: dap.Source{Path: path}, Breakpoints: breakpoints})
+	for _, bp := range await[*dap.SetBreakpointsResponse](c).Body.Breakpoints {
+		if !bp.Verified && !strings.HasPrefix(bp.Message, "Pending") {
+			c.t.Fatalf("breakpoint at line %d not set: %s", bp.Line, bp.Message)
+		}
+	}
+}
+
+// variables returns the variables of ref by name.
+func (c *testClient) variables(ref int) map[string]dap.Variable {
+	c.t.Helper()
+	c.send("variables", dap.VariablesArguments{VariablesReference: ref})
+	out := map[string]dap.Variable{}
+	for _, v := range await[*dap.VariablesResponse](c).Body.Variables {
+		out[v.Name] = v
+	}
+	return out
+}
+
+// scopes returns the scopes of the innermost stack frame by name.
+func (c *testClient) scopes() map[string]dap.Scope {
+	c.t.Helper()
+	c.send("scopes", dap.ScopesArguments{FrameId: 0})
+	out := map[string]dap.Scope{}
+	for _, s := range await[*dap.ScopesResponse](c).Body.Scopes {
+		out[s.Name] = s
+	}
+	return out
+}
+
+// evaluate evaluates expr in the innermost stack frame.
+func (c *testClient) evaluate(expr string) string {
+	c.t.Helper()
+	c.send("evaluate", dap.EvaluateArguments{Expression: expr, Context: "repl"})
+	return await[*dap.EvaluateResponse](c).Body.Result
+}
+
+// finish continues until the program exits and returns the messages logged
+// meanwhile, e.g. by logpoints.
+func (c *testClient) finish() string {
+	c.t.Helper()
+	c.send("continue", dap.ContinueArguments{ThreadId: 1})
+	await[*dap.ContinueResponse](c)
+	var out strings.Builder
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				c.t.Fatal("the connection was closed before the program exited")
+			}
+			switch e := msg.(type) {
+			case *dap.OutputEvent:
+				if e.Body.Category != "stderr" {
+					out.WriteString(e.Body.Output)
+				}
+			case *dap.TerminatedEvent:
+				return out.String()
+			case *dap.StoppedEvent:
+				c.send("continue", dap.ContinueArguments{ThreadId: 1})
+			}
+		case <-time.After(10 * time.Second):
+			c.t.Fatal("timed out waiting for the program to exit")
+		}
+	}
+}
+
+func TestExceptionInfo(t *testing.T) {
+	c := launchSession(t, `local double(x) =
+  x * 2;
+double('a')
+`, nil)
+	stopped := await[*dap.StoppedEvent](c)
+	if stopped.Body.Reason != "exception" {
+		t.Fatalf("stopped for %s, want an exception", stopped.Body.Reason)
+	}
+	c.send("exceptionInfo", dap.ExceptionInfoArguments{ThreadId: 1})
+	info := await[*dap.ExceptionInfoResponse](c).Body
+	if info.ExceptionId != exceptionFilterRuntime || info.Details.TypeName != exceptionFilterRuntime {
+		t.Errorf("exception %s of type %s, want %s", info.ExceptionId, info.Details.TypeName, exceptionFilterRuntime)
+	}
+	if !strings.HasSuffix(info.Details.Message, "\nx = \"a\" (string)") || info.Details.Message != info.Description {
+		t.Errorf("got message %q and description %q", info.Details.Message, info.Description)
+	}
+	if info.Details.EvaluateName != "x" {
+		t.Errorf("offending value %q, want x", info.Details.EvaluateName)
+	}
+}
+
+func TestFunctionBreakpoints(t *testing.T) {
+	breakpoints := []dap.FunctionBreakpoint{{Name: "answer"}, {Name: "double"}}
+	c := launchSession(t, `local answer() = 42;
+local double(x) = x * 2;
+[answer(), double(21)]
+`, func(c *testClient, path string) {
+		c.send("setFunctionBreakpoints", dap.SetFunctionBreakpointsArguments{Breakpoints: breakpoints})
+		await[*dap.SetFunctionBreakpointsResponse](c)
+	})
+	// answer returns a literal, the evaluation hooks stop there
+	for _, want := range []int{1, 2} {
+		stopped := await[*dap.StoppedEvent](c)
+		if stopped.Body.Reason != "function breakpoint" {
+			t.Fatalf("stopped for %s, want a function breakpoint", stopped.Body.Reason)
+		}
+		c.send("stackTrace", dap.StackTraceArguments{ThreadId: 1})
+		if line := await[*dap.StackTraceResponse](c).Body.StackFrames[0].Line; line != want {
+			t.Fatalf("stopped on line %d, want %d", line, want)
+		}
+		if want == 1 {
+			c.send("continue", dap.ContinueArguments{ThreadId: 1})
+			await[*dap.ContinueResponse](c)
+		}
+	}
+	if x := c.evaluate("x"); x != "21" {
+		t.Errorf("x = %s, want 21", x)
+	}
+	c.send("setFunctionBreakpoints", dap.SetFunctionBreakpointsArguments{Breakpoints: breakpoints})
+	got := await[*dap.SetFunctionBreakpointsResponse](c).Body.Breakpoints
+	if len(got) != 2 {
+		t.Fatalf("got %d breakpoints, want 2", len(got))
+	}
+	if !got[0].Verified || got[0].Line != 1 || got[0].Message != "Hit 1 times" {
+		t.Errorf("answer: verified %v at line %d with message %q", got[0].Verified, got[0].Line, got[0].Message)
+	}
+	if !got[1].Verified || got[1].Line != 2 {
+		t.Errorf("double: verified %v at line %d: %s", got[1].Verified, got[1].Line, got[1].Message)
+	}
+	c.send("setFunctionBreakpoints", dap.SetFunctionBreakpointsArguments{})
+	await[*dap.SetFunctionBreakpointsResponse](c)
+	if out := c.finish(); out != "" {
+		t.Errorf("got output %q", out)
+	}
+}
+
+func TestStopEvaluateAndSetVariable(t *testing.T) {
+	c := launchSession(t, `local scale = 10;
+local f(x) =
+  local y = x * scale;
+  y + 1;
+[f(i) for i in [1, 2, 3]]
+`, func(c *testClient, path string) {
+		c.setBreakpoints(path,
+			dap.SourceBreakpoint{Line: 3, LogMessage: "x={x}"},
+			dap.SourceBreakpoint{Line: 4, Condition: "x == 2"},
+		)
+	})
+	for _, want := range []string{"x=1\n", "x=2\n"} {
+		if out := await[*dap.OutputEvent](c).Body.Output; out != want {
+			t.Errorf("logged %q, want %q", out, want)
+		}
+	}
+	stopped := await[*dap.StoppedEvent](c)
+	if stopped.Body.Reason != "breakpoint" || len(stopped.Body.HitBreakpointIds) != 1 {
+		t.Fatalf("stopped for %s at %v, want the breakpoint on line 4", stopped.Body.Reason, stopped.Body.HitBreakpointIds)
+	}
+
+	scopes := c.scopes()
+	for scope, want := range map[string]map[string]string{
+		"Arguments": {"x": "2"},
+		"Locals":    {"y": "20"},
+		"Closure":   {"scale": "10"},
+	} {
+		got := c.variables(scopes[scope].VariablesReference)
+		for name, value := range want {
+			if got[name].Value != value {
+				t.Errorf("%s: %s = %q, want %s", scope, name, got[name].Value, value)
+			}
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: got variables %v, want %v", scope, got, want)
+		}
+	}
+
+	c.send("setVariable", dap.SetVariableArguments{VariablesReference: scopes["Locals"].VariablesReference, Name: "y", Value: "x + 3"})
+	if v := await[*dap.SetVariableResponse](c).Body; v.Value != "5" || v.Type != "number" {
+		t.Errorf("set y to %s of type %s, want 5", v.Value, v.Type)
+	}
+	if got := c.evaluate("y + 1"); got != "6" {
+		t.Errorf("y + 1 = %s after setting y, want 6", got)
+	}
+	// References are invalidated by the assignment
+	if got := c.variables(c.scopes()["Locals"].VariablesReference)["y"].Value; got != "5" {
+		t.Errorf("y = %s after setting it, want 5", got)
+	}
+	if out := c.finish(); out != "x=3\n" {
+		t.Errorf("got output %q", out)
+	}
+}
+
+func TestStdFunctionBreakpoints(t *testing.T) {
+	c := launchSession(t, "local xs = [3, 1];\nstd.sort(xs)\n", func(c *testClient, path string) {
+		c.send("setFunctionBreakpoints", dap.SetFunctionBreakpointsArguments{Breakpoints: []dap.FunctionBreakpoint{{Name: "std.sort"}}})
+		await[*dap.SetFunctionBreakpointsResponse](c)
+	})
+	// Function breakpoints are resolved on launch
+	changed := await[*dap.BreakpointEvent](c).Body.Breakpoint
+	if !changed.Verified || changed.Line != 2 || !strings.Contains(changed.Message, "stop where they are called") {
+		t.Errorf("verified %v at line %d: %s", changed.Verified, changed.Line, changed.Message)
+	}
+	if stopped := await[*dap.StoppedEvent](c); stopped.Body.Reason != "function breakpoint" {
+		t.Fatalf("stopped for %s, want the call of std.sort", stopped.Body.Reason)
+	}
+	c.finish()
+}
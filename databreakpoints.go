@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+)
+
+// fieldDefinition is where the debugger has to stop for a data breakpoint:
+// the body of a field, which is evaluated when the field is forced.
+type fieldDefinition struct {
+	file string
+	// object is the object literal the field is defined in. Objects built
+	// by inheritance get each field from one of their layers.
+	object ast.LocationRange
+	at     ast.LocationRange
+	// literal is set for fields with literal values, which the debugger
+	// only stops at through evalHooks.breakAtLiterals.
+	literal bool
+}
+
+// findFields returns all definitions of fields named name in files. Fields
+// with computed names are not included.
+func findFields(files []*programFile, name string) []fieldDefinition {
+	defs := []fieldDefinition{}
+	for _, f := range files {
+		walk(f.node, func(n ast.Node) {
+			obj, ok := n.(*ast.DesugaredObject)
+			if !ok || obj.Loc().File == nil {
+				return
+			}
+			for _, field := range obj.Fields {
+				fieldName, ok := field.Name.(*ast.LiteralString)
+				if !ok || fieldName.Value != name || field.Body == nil || field.Body.Loc().File == nil {
+					continue
+				}
+				def := fieldDefinition{
+					file:   f.path,
+					object: *obj.Loc(),
+					at:     *field.Body.Loc(),
+				}
+				switch field.Body.(type) {
+				case *ast.LiteralNull, *ast.LiteralNumber, *ast.LiteralString, *ast.LiteralBoolean:
+					def.literal = true
+				}
+				defs = append(defs, def)
+			}
+		})
+	}
+	return defs
+}
+
+// dataID builds the id of a data breakpoint on field: `*.field` for the
+// field of any object, `#N.field` for the field of the watched object N.
+func dataID(object int, field string) string {
+	if object < 0 {
+		return "*." + field
+	}
+	return "#" + strconv.Itoa(object) + "." + field
+}
+
+// parseDataID is the inverse of dataID, object is -1 for any object.
+func parseDataID(id string) (object int, field string, err error) {
+	owner, field, ok := strings.Cut(id, ".")
+	switch {
+	case !ok || field == "":
+	case owner == "*":
+		return -1, field, nil
+	case strings.HasPrefix(owner, "#"):
+		if object, err := strconv.Atoi(owner[1:]); err == nil && object >= 0 {
+			return object, field, nil
+		}
+	}
+	return 0, "", fmt.Errorf("Invalid data breakpoint %q", id)
+}
+
+// splitField splits expr into the object it accesses a field of and the
+// field name. A plain identifier stands for the field of that name in any
+// object, in which case the object is nil.
+func splitField(expr string) (ast.Node, string, error) {
+	node, _, err := formatter.SnippetToRawAST("<data breakpoint>", expr)
+	if err != nil {
+		return nil, "", err
+	}
+	switch n := node.(type) {
+	case *ast.Var:
+		return nil, string(n.Id), nil
+	case *ast.Index:
+		if n.Id != nil {
+			return n.Target, string(*n.Id), nil
+		}
+		if s, ok := n.Index.(*ast.LiteralString); ok {
+			return n.Target, s.Value, nil
+		}
+	}
+	return nil, "", fmt.Errorf("Only object fields can be watched")
+}
+
+// watchedObject returns the object node evaluates to. It has to be reachable
+// from a variable, self or $ through fields that have been evaluated.
+func watchedObject(dbg *jsonnet.Debugger, node ast.Node) (reflect.Value, error) {
+	obj, err := runtimeValue(dbg, node)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if !isValueType(obj, "valueObject") {
+		return reflect.Value{}, fmt.Errorf("Only fields of evaluated objects can be watched")
+	}
+	return obj, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// writeProgram writes src as the main file of a program and resolves it.
+func writeProgram(t *testing.T, src string) (string, []*programFile) {
+	t.Helper()
+	filename := writeFile(t, src)
+	program, err := resolveProgram(filename, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename, program
+}
+
+func TestFindFields(t *testing.T) {
+	_, program := writeProgram(t, `local name = 'replicas';
+{
+  spec: { replicas: 3 },
+  scaled: { replicas: $.spec.replicas * 2 },
+  [name]: 1,
+}
+`)
+	defs := findFields(program, "replicas")
+	if len(defs) != 2 {
+		t.Fatalf("found %d definitions, want 2", len(defs))
+	}
+	for i, want := range []struct {
+		line, column int
+		literal      bool
+	}{
+		{3, 21, true},
+		{4, 23, false},
+	} {
+		def := defs[i]
+		if def.at.Begin.Line != want.line || def.at.Begin.Column != want.column || def.literal != want.literal {
+			t.Errorf("definition %d at %v, literal %v, want %d:%d, literal %v",
+				i, def.at.Begin, def.literal, want.line, want.column, want.literal)
+		}
+	}
+}
+
+func TestBreakAtLiterals(t *testing.T) {
+	src := `{
+  spec: { replicas: 3, name: 'app' },
+  total: self.spec.replicas + 1,
+}
+`
+	filename, program := writeProgram(t, src)
+	defs := findFields(program, "replicas")
+	if len(defs) != 1 {
+		t.Fatalf("found %d definitions, want 1", len(defs))
+	}
+	dbg := jsonnet.MakeDebugger()
+	var h evalHooks
+	if err := h.install(dbg); err != nil {
+		t.Fatal(err)
+	}
+	bp := &breakpoint{file: defs[0].file, line: defs[0].at.Begin.Line, column: defs[0].at.Begin.Column}
+	bp.setLiteral(defs[0].at)
+	if err := h.breakAtLiterals([]ast.LocationRange{*bp.at}); err != nil {
+		t.Fatal(err)
+	}
+	dbg.Launch(filename, src, nil)
+	stop := waitStop(t, dbg)
+	if stop == nil {
+		t.Fatal("the program exited without stopping at the literal")
+	}
+	if _, ok := stop.Current.(*ast.LiteralNumber); !ok || stop.Reason != jsonnet.StopReasonBreakpoint {
+		t.Fatalf("stopped at a %T for %v, want a breakpoint on the literal", stop.Current, stop.Reason)
+	}
+	if got := stop.Current.Loc().String(); got != bp.location {
+		t.Errorf("stopped at %s, want %s", got, bp.location)
+	}
+	self, err := selfObject(dbg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := objectFields(self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 2 {
+		t.Errorf("stopped in an object with fields %v, want spec", fields)
+	}
+	if out := finish(t, dbg); out != "{\n   \"spec\": {\n      \"name\": \"app\",\n      \"replicas\": 3\n   },\n   \"total\": 4\n}\n" {
+		t.Errorf("got output %q", out)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+)
+
+// Names under which the debugger binds values for the interpreter.
+const (
+	// evalValue binds values evaluated by the interpreter of the program.
+	evalValue = "__debugger_value"
+	// evalResult binds the expression evaluated by evaluateNode.
+	evalResult = "__debugger_result"
+)
+
+// parseExpression parses expr into a desugared and analyzed AST that can be
+// evaluated with evaluateNode in the environment the debugger is stopped in.
+//
+// The expression is parsed as the field of an object with the variables of
+// the environment declared around it, so the static analysis accepts them
+// as well as self, super and $.
+func parseExpression(dbg *jsonnet.Debugger, expr string) (ast.Node, error) {
+	if err := checkExpression(expr); err != nil {
+		return nil, err
+	}
+	var sb strings.Builder
+	seen := map[string]bool{"std": true}
+	for _, v := range dbg.ListVars() {
+		name := string(v)
+		if seen[name] || !identifierPattern.MatchString(name) || keywords[name] {
+			continue
+		}
+		seen[name] = true
+		fmt.Fprintf(&sb, "local %s = null;\n", name)
+	}
+	fmt.Fprintf(&sb, "{%s: (\n%s\n)}", evalValue, expr)
+	node, err := jsonnet.SnippetToAST("<expression>", sb.String())
+	if err != nil {
+		// The location points into the generated snippet
+		msg := err.Error()
+		if err, ok := err.(interface{ Loc() ast.LocationRange }); ok {
+			loc := err.Loc()
+			msg = strings.TrimPrefix(msg, loc.String()+" ")
+		}
+		return nil, fmt.Errorf("%s", msg)
+	}
+	for {
+		local, ok := node.(*ast.Local)
+		if !ok {
+			break
+		}
+		node = local.Body
+	}
+	obj, ok := node.(*ast.DesugaredObject)
+	if !ok || len(obj.Fields) != 1 {
+		return nil, fmt.Errorf("unsupported version of go-jsonnet: objects are desugared into %T", node)
+	}
+	return obj.Fields[0].Body, nil
+}
+
+// evaluateValue evaluates expr with the interpreter of the program, in the
+// environment the debugger is stopped in.
+func evaluateValue(dbg *jsonnet.Debugger, expr string) (reflect.Value, error) {
+	node, err := parseExpression(dbg, expr)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	v, err := evaluateNode(dbg, node, nil)
+	if err != nil {
+		return reflect.Value{}, trimError(err)
+	}
+	return v, nil
+}
+
+// trimError removes the prefix of runtime errors and their stack trace,
+// which points into the debugger.
+func trimError(err error) error {
+	msg, _, _ := strings.Cut(err.Error(), "\n")
+	return fmt.Errorf("%s", strings.TrimPrefix(msg, "RUNTIME ERROR: "))
+}
+
+// manifestValue renders v as JSON with the interpreter of the program,
+// evaluating what has not been evaluated yet. The output is compact if
+// indent is empty.
+func manifestValue(dbg *jsonnet.Debugger, v reflect.Value, indent string) (string, error) {
+	expr := fmt.Sprintf("std.manifestJsonEx(%s, %s)", evalValue, quote(indent))
+	if indent == "" {
+		expr = fmt.Sprintf("std.manifestJsonEx(%s, '', '', ':')", evalValue)
+	}
+	node, err := manifestNode(expr)
+	if err != nil {
+		return "", err
+	}
+	out, err := evaluateNode(dbg, node, map[string]reflect.Value{evalValue: v})
+	if err != nil {
+		return "", trimError(err)
+	}
+	s, err := inspectValue(out, 0)
+	if err != nil {
+		return "", err
+	}
+	return s.scalar, nil
+}
+
+// manifestNodes caches the ASTs of manifestValue.
+var manifestNodes sync.Map
+
+func manifestNode(expr string) (ast.Node, error) {
+	if node, ok := manifestNodes.Load(expr); ok {
+		return node.(ast.Node), nil
+	}
+	node, err := valueNode(expr)
+	if err != nil {
+		return nil, err
+	}
+	manifestNodes.Store(expr, node)
+	return node, nil
+}
+
+// valueNode parses expr, which refers to a value bound by the debugger as
+// evalValue.
+func valueNode(expr string) (ast.Node, error) {
+	node, err := jsonnet.SnippetToAST("<debugger>", fmt.Sprintf("local %s = null; %s", evalValue, expr))
+	if err != nil {
+		return nil, err
+	}
+	local, ok := node.(*ast.Local)
+	if !ok {
+		return nil, fmt.Errorf("unsupported version of go-jsonnet: locals are desugared into %T", node)
+	}
+	return local.Body, nil
+}
+
+// indexValue returns the field or element index of the object or array v,
+// evaluating it like the program would if it has not been evaluated yet.
+// index is a Jsonnet string or number literal.
+func indexValue(dbg *jsonnet.Debugger, v reflect.Value, index string) (reflect.Value, error) {
+	node, err := valueNode(evalValue + "[" + index + "]")
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	out, err := evaluateNode(dbg, node, map[string]reflect.Value{evalValue: v})
+	if err != nil {
+		return reflect.Value{}, trimError(err)
+	}
+	return out, nil
+}
+
+// evaluate evaluates expr for display. Only the parts of the result that
+// have already been evaluated are included, down to previewDepth, which
+// also works for functions and for values that are only partially
+// evaluated.
+func evaluate(dbg *jsonnet.Debugger, expr string) (*debugValue, error) {
+	v, err := evaluateValue(dbg, expr)
+	if err != nil {
+		return nil, err
+	}
+	return inspectValue(v, previewDepth)
+}
+
+// printValue evaluates expr and renders the result in full as JSON, or
+// only the parts evaluated so far if it cannot be manifested.
+func printValue(dbg *jsonnet.Debugger, expr string) (string, error) {
+	v, err := evaluateValue(dbg, expr)
+	if err != nil {
+		return "", err
+	}
+	if out, err := manifestValue(dbg, v, "  "); err == nil {
+		return out, nil
+	}
+	inspected, err := inspectValue(v, -1)
+	if err != nil {
+		return "", err
+	}
+	return inspected.String(), nil
+}
+
+// evaluateCondition evaluates expr like evaluateValue and requires the
+// result to be a boolean.
+func evaluateCondition(dbg *jsonnet.Debugger, expr string) (bool, error) {
+	v, err := evaluateValue(dbg, expr)
+	if err != nil {
+		return false, err
+	}
+	out, err := inspectValue(v, 0)
+	if err != nil {
+		return false, err
+	}
+	if out.kind != valueKindBoolean {
+		return false, fmt.Errorf("condition must evaluate to a boolean, got %s", out.kind)
+	}
+	return out.scalar == "true", nil
+}
+
+// interpolateMessage replaces every `{expr}` placeholder of a log message
+// with the value of expr. Literal braces are written as `{{` and `}}`.
+func interpolateMessage(dbg *jsonnet.Debugger, msg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if (c == '{' || c == '}') && i+1 < len(msg) && msg[i+1] == c {
+			sb.WriteByte(c)
+			i++
+			continue
+		}
+		if c != '{' {
+			sb.WriteByte(c)
+			continue
+		}
+		// Find the matching brace, expressions may contain objects
+		depth, end := 1, -1
+		for j := i + 1; j < len(msg) && end < 0; j++ {
+			switch msg[j] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					end = j
+				}
+			}
+		}
+		if end < 0 {
+			sb.WriteString(msg[i:])
+			break
+		}
+		out, err := formatLogValue(dbg, msg[i+1:end])
+		if err != nil {
+			sb.WriteString("<error: " + err.Error() + ">")
+		} else {
+			sb.WriteString(out)
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+// formatLogValue evaluates expr and prints strings without quotes and
+// everything else as compact JSON. Values that cannot be manifested, like
+// functions, are printed like in the variables view.
+func formatLogValue(dbg *jsonnet.Debugger, expr string) (string, error) {
+	v, err := evaluateValue(dbg, expr)
+	if err != nil {
+		return "", err
+	}
+	if isValueType(v, "valueFlatString") || isValueType(v, "valueStringTree") {
+		s, err := inspectValue(v, 0)
+		if err != nil {
+			return "", err
+		}
+		return s.scalar, nil
+	}
+	out, err := manifestValue(dbg, v, "")
+	if err != nil {
+		inspected, inspectErr := inspectValue(v, -1)
+		if inspectErr != nil || inspected.kind != valueKindFunction {
+			return "", err
+		}
+		return inspected.String(), nil
+	}
+	return out, nil
+}
+
+// lookupValue returns the value of the variable name, or of self or $, as
+// it is inspected by the debugger. Variables that have not been evaluated
+// yet are forced in their own environment, like the program would.
+func lookupValue(dbg *jsonnet.Debugger, name string) (*debugValue, error) {
+	var v reflect.Value
+	var err error
+	switch name {
+	case "self":
+		v, err = selfObject(dbg)
+		if err == nil && !v.IsValid() {
+			err = fmt.Errorf("Can't use self outside of an object.")
+		}
+	default:
+		v, err = forceVariable(dbg, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inspectValue(v, previewDepth)
+}
+
+// forceVariable returns the value of the variable name, forcing it if it
+// has not been evaluated yet.
+func forceVariable(dbg *jsonnet.Debugger, name string) (reflect.Value, error) {
+	thunk, err := lookupVariable(dbg, name)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if !thunk.IsValid() {
+		if name == "$" {
+			return reflect.Value{}, fmt.Errorf("No top-level object found.")
+		}
+		return reflect.Value{}, fmt.Errorf("Unknown variable: %s", name)
+	}
+	if v, err := thunkValue(thunk); err != nil || v.IsValid() {
+		return v, err
+	}
+	node := &ast.Var{Id: ast.Identifier(name)}
+	node.SetFreeVariables(ast.Identifiers{node.Id})
+	v, err := evaluateNode(dbg, node, nil)
+	if err != nil {
+		return reflect.Value{}, trimError(err)
+	}
+	return v, nil
+}
+
+// checkExpression reports syntax errors in expr without evaluating it.
+func checkExpression(expr string) error {
+	_, _, err := formatter.SnippetToRawAST("<expression>", expr)
+	return err
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-jsonnet"
+)
+
+// stopAt launches src with a breakpoint at line and column and waits until
+// the debugger stops there.
+func stopAt(t *testing.T, src string, line, column int) *jsonnet.Debugger {
+	t.Helper()
+	return stopWith(t, src, line, column, nil)
+}
+
+// writeFile writes src as the main file of a program and returns its path.
+func writeFile(t *testing.T, src string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "main.jsonnet")
+	if err := os.WriteFile(filename, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// stopWith is stopAt calling setup, if not nil, before launching.
+func stopWith(t *testing.T, src string, line, column int, setup func(*jsonnet.Debugger) error) *jsonnet.Debugger {
+	t.Helper()
+	filename := writeFile(t, src)
+	dbg := jsonnet.MakeDebugger()
+	if _, err := dbg.SetBreakpoint(filename, line, column); err != nil {
+		t.Fatal(err)
+	}
+	if setup != nil {
+		if err := setup(dbg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbg.Launch(filename, src, nil)
+	if stop := waitStop(t, dbg); stop == nil {
+		t.Fatal("the program exited before reaching the breakpoint")
+	}
+	return dbg
+}
+
+// waitStop waits for the next event of dbg and returns it if it is a stop,
+// or nil if the program exited.
+func waitStop(t *testing.T, dbg *jsonnet.Debugger) *jsonnet.DebugEventStop {
+	t.Helper()
+	select {
+	case e := <-dbg.Events():
+		switch e := e.(type) {
+		case *jsonnet.DebugEventStop:
+			return e
+		case *jsonnet.DebugEventExit:
+			if e.Error != nil {
+				t.Fatal(e.Error)
+			}
+			return nil
+		}
+		t.Fatalf("unexpected event %T", e)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the debugger")
+	}
+	return nil
+}
+
+// finish continues until the program exits, passing any further stops, and
+// returns its output.
+func finish(t *testing.T, dbg *jsonnet.Debugger) string {
+	t.Helper()
+	for {
+		dbg.Continue()
+		select {
+		case e := <-dbg.Events():
+			if e, ok := e.(*jsonnet.DebugEventExit); ok {
+				if e.Error != nil {
+					t.Fatal(e.Error)
+				}
+				return e.Output
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the program to exit")
+		}
+	}
+}
+
+func TestEvaluateConditionArguments(t *testing.T) {
+	src := `local f(name) =
+  local i = 100;
+  i + name;
+[f(i) for i in [1]]
+`
+	dbg := stopAt(t, src, 3, 3)
+	for _, tc := range []struct {
+		expr string
+		want bool
+	}{
+		{"name == 1", true},
+		{"i == 100", true},
+		{"i + name == 101", true},
+		{"name == 100", false},
+	} {
+		got, err := evaluateCondition(dbg, tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+		} else if got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+	if out := finish(t, dbg); out != "[\n   101\n]\n" {
+		t.Errorf("evaluating conditions changed the output to %q", out)
+	}
+}
+
+func TestEvaluateConditionShadowed(t *testing.T) {
+	src := `local x = 1;
+local g(y) =
+  local x = 2;
+  y * 10 + x;
+g(x)
+`
+	dbg := stopAt(t, src, 4, 3)
+	got, err := evaluateCondition(dbg, "y == 1 && x == 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got {
+		t.Error("y == 1 && x == 2 is false")
+	}
+	if _, err := evaluateCondition(dbg, "x"); err == nil || err.Error() != "condition must evaluate to a boolean, got number" {
+		t.Errorf("non boolean condition: %v", err)
+	}
+	if _, err := evaluateCondition(dbg, "z == 1"); err == nil {
+		t.Error("unknown variables are not reported")
+	}
+	if out := finish(t, dbg); out != "12\n" {
+		t.Errorf("evaluating conditions changed the output to %q", out)
+	}
+}
+
+func TestLookupValue(t *testing.T) {
+	src := `local f(name) =
+  local s = 'say "hi"';
+  local small = 0.0000001;
+  s + name;
+[f(i) for i in ['!']]
+`
+	dbg := stopAt(t, src, 4, 3)
+	for name, want := range map[string]string{
+		"name":  `"!"`,
+		"s":     `"say \"hi\""`,
+		"small": "1e-07",
+	} {
+		v, err := lookupValue(dbg, name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if got := v.String(); got != want {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+	if out := finish(t, dbg); out != "[\n   \"say \\\"hi\\\"!\"\n]\n" {
+		t.Errorf("looking up values changed the output to %q", out)
+	}
+}
+
+func TestInterpolateMessage(t *testing.T) {
+	src := `local f(name) =
+  local obj = { a: name, b:: 'hidden', c: self.a + 1 };
+  local g(x) = x;
+  obj.c;
+[f(i) for i in [1]]
+`
+	dbg := stopAt(t, src, 4, 3)
+	got := interpolateMessage(dbg, `name={name} obj={obj} b={obj.b} s={'x"y'} g={g} {{literal}} err={z}`)
+	want := `name=1 obj={"a":1,"c":2} b=hidden s=x"y g=function(x) {literal} err=<error: Unknown variable: z>`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	if out := finish(t, dbg); out != "[\n   2\n]\n" {
+		t.Errorf("interpolating the message changed the output to %q", out)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	src := `local double(n) = n * 2;
+{
+  doubled: double(self.replicas),
+  replicas: 3,
+  spec: { replicas: 2 },
+}
+`
+	dbg := stopAt(t, src, 3, 12)
+	for _, tc := range []struct{ expr, want string }{
+		{"self.replicas * 2", "6"},
+		{"self", "{doubled: <not evaluated>, replicas: 3, spec: <not evaluated>}"},
+		{"$.spec.replicas", "2"},
+		{"double(4)", "8"},
+		{"std.length([x * 2 for x in [1]])", "1"},
+		{"local y = 1; y + 0.5", "1.5"},
+		{"{ a: self.b, b:: 1 }", "{a: <not evaluated>, b:: <not evaluated>}"},
+		{"{ a: self.b, b:: 1 }.a", "1"},
+	} {
+		v, err := evaluate(dbg, tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+		} else if got := v.String(); got != tc.want {
+			t.Errorf("%s = %s, want %s", tc.expr, got, tc.want)
+		}
+	}
+	if _, err := evaluate(dbg, "missing"); err == nil || err.Error() != "Unknown variable: missing" {
+		t.Errorf("unknown variable: %v", err)
+	}
+	if _, err := evaluate(dbg, "1 +"); err == nil {
+		t.Error("syntax errors are not reported")
+	}
+	want := "{\n   \"doubled\": 6,\n   \"replicas\": 3,\n   \"spec\": {\n      \"replicas\": 2\n   }\n}\n"
+	if out := finish(t, dbg); out != want {
+		t.Errorf("evaluating changed the output to %q", out)
+	}
+}
+
+func TestEvaluateDollarInFunction(t *testing.T) {
+	src := `local double(n) =
+  n * 2;
+{
+  doubled: double(self.replicas),
+  replicas: 3,
+}
+`
+	dbg := stopAt(t, src, 2, 3)
+	v, err := evaluate(dbg, "$.replicas + n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.String(); got != "6" {
+		t.Errorf("$.replicas + n = %s, want 6", got)
+	}
+	if _, err := evaluate(dbg, "self"); err == nil {
+		t.Error("self is available outside of objects")
+	}
+	finish(t, dbg)
+}
+
+func TestPrintValue(t *testing.T) {
+	src := `local f(n) =
+  local g(x) = x;
+  n + 1;
+f(1)
+`
+	dbg := stopAt(t, src, 3, 3)
+	for _, tc := range []struct{ expr, want string }{
+		{"[x * 2 for x in [n]]", "[\n  2\n]"},
+		{"{ a: n, b:: 2 }", "{\n  \"a\": 1\n}"},
+		{"g", "function(x)"},
+		{"g(n)", "1"},
+	} {
+		got, err := printValue(dbg, tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+		} else if got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.expr, got, tc.want)
+		}
+	}
+	finish(t, dbg)
+}
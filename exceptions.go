@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+)
+
+// Exception filters, selecting the errors the debugger stops at.
+const (
+	// exceptionFilterError matches `error` expressions in the program.
+	exceptionFilterError = "error"
+	// exceptionFilterAssert matches failed assertions, both in expressions
+	// and in objects.
+	exceptionFilterAssert = "assert"
+	// exceptionFilterRuntime matches errors raised by the interpreter or the
+	// standard library, e.g. type errors, missing fields or indexes out of
+	// bounds.
+	exceptionFilterRuntime = "runtime"
+	// exceptionFilterAll matches every error, at every node it propagates
+	// through, so errors are reported even if an outer evaluation ends up
+	// discarding them.
+	exceptionFilterAll = "all"
+)
+
+// exceptionBreakpoints decides which errors to stop at. The debugger reports
+// an error once for every node it propagates through, but apart from the
+// "all" filter only the node raising it is considered.
+type exceptionBreakpoints struct {
+	mu sync.Mutex
+	// filters maps the enabled filters to the pattern the error message
+	// has to match, nil matches any message.
+	filters map[string]*regexp.Regexp
+	// raised is the last error reported by the debugger.
+	raised error
+	// stoppedAt is the exception the debugger is stopped at, if any.
+	stoppedAt *jsonnet.DebugEventStop
+}
+
+// newExceptionBreakpoints stops wherever an error is raised until the
+// filters are configured.
+func newExceptionBreakpoints() *exceptionBreakpoints {
+	return &exceptionBreakpoints{filters: map[string]*regexp.Regexp{
+		exceptionFilterError:   nil,
+		exceptionFilterAssert:  nil,
+		exceptionFilterRuntime: nil,
+	}}
+}
+
+func (e *exceptionBreakpoints) set(filters map[string]*regexp.Regexp) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.filters = filters
+}
+
+// setStoppedAt records the exception the debugger is stopped at, nil when
+// it is stopped for another reason or running.
+func (e *exceptionBreakpoints) setStoppedAt(ev *jsonnet.DebugEventStop) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stoppedAt = ev
+}
+
+func (e *exceptionBreakpoints) current() *jsonnet.DebugEventStop {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stoppedAt
+}
+
+// shouldStop returns whether to stop at the exception ev, and the filter
+// that caused it.
+func (e *exceptionBreakpoints) shouldStop(ev *jsonnet.DebugEventStop) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	raised := !sameError(e.raised, ev.Error)
+	e.raised = ev.Error
+	candidates := []string{exceptionFilterAll}
+	if raised {
+		candidates = []string{classifyException(ev.Current), exceptionFilterAll}
+	}
+	for _, filter := range candidates {
+		pattern, ok := e.filters[filter]
+		if ok && (pattern == nil || pattern.MatchString(exceptionMessage(ev.Error))) {
+			return filter, true
+		}
+	}
+	return "", false
+}
+
+// exceptionMessage returns the message of err, without the prefix added to
+// runtime errors.
+func exceptionMessage(err error) string {
+	var re jsonnet.RuntimeError
+	if errors.As(err, &re) {
+		return re.Msg
+	}
+	return err.Error()
+}
+
+// sameError reports whether a and b are the same error propagating through
+// the evaluation. Runtime errors are copied on their way up, but keep their
+// message and the stack trace of where they were raised.
+func sameError(a, b error) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	var ra, rb jsonnet.RuntimeError
+	if errors.As(a, &ra) && errors.As(b, &rb) {
+		return ra.Msg == rb.Msg && slices.Equal(ra.StackTrace, rb.StackTrace)
+	}
+	return a.Error() == b.Error()
+}
+
+// classifyException returns the filter matching an error raised at node.
+// Assertions are desugared into `error` expressions spanning the assertion,
+// so they are told apart by their source.
+func classifyException(node ast.Node) string {
+	n, ok := node.(*ast.Error)
+	if !ok {
+		return exceptionFilterRuntime
+	}
+	loc := n.Loc()
+	if loc.File == nil || loc.File.DiagnosticFileName == "<std>" {
+		return exceptionFilterRuntime
+	}
+	if strings.HasPrefix(nodeSource(n), "assert") {
+		return exceptionFilterAssert
+	}
+	return exceptionFilterError
+}
+
+// nodeSource returns the source code of node, or an empty string if it is
+// not available.
+func nodeSource(node ast.Node) string {
+	return locationSource(node.Loc())
+}
+
+// locationSource returns the source code at loc, or an empty string if it
+// is not available.
+func locationSource(loc *ast.LocationRange) string {
+	if loc == nil || loc.File == nil || loc.Begin.Line < 1 || loc.End.Line > len(loc.File.Lines) || loc.Begin.Line > loc.End.Line {
+		return ""
+	}
+	var sb strings.Builder
+	for l := loc.Begin.Line; l <= loc.End.Line; l++ {
+		line := []rune(loc.File.Lines[l-1])
+		begin, end := 0, len(line)
+		if l == loc.Begin.Line {
+			begin = min(max(loc.Begin.Column-1, 0), len(line))
+		}
+		if l == loc.End.Line {
+			end = min(max(loc.End.Column-1, begin), len(line))
+		}
+		sb.WriteString(string(line[begin:end]))
+	}
+	return sb.String()
+}
+
+// typeErrorPattern matches the messages of type errors, capturing the type
+// of the offending value.
+var typeErrorPattern = regexp.MustCompile(`(?:^Unexpected type |, got )(\w+)`)
+
+// offendingValue returns the operand of node with the type a type error
+// complains about, with its source. Operands are looked up like hovered
+// expressions first, so only simple expressions such as literals are
+// evaluated again.
+func offendingValue(dbg *jsonnet.Debugger, node ast.Node, err error) (string, *debugValue, bool) {
+	m := typeErrorPattern.FindStringSubmatch(exceptionMessage(err))
+	if m == nil || node == nil {
+		return "", nil, false
+	}
+	for _, operand := range toolutils.Children(node) {
+		expr := nodeSource(operand)
+		if expr == "" {
+			continue
+		}
+		v, ok := hover(dbg, expr)
+		if !ok {
+			if !isSimple(operand) {
+				continue
+			}
+			var err error
+			if v, err = evaluate(dbg, expr); err != nil {
+				continue
+			}
+		}
+		if v.kind.String() == m[1] {
+			return expr, v, true
+		}
+	}
+	return "", nil, false
+}
+
+// isSimple reports whether evaluating node cannot fail nor take long, as it
+// only consists of literals.
+func isSimple(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.LiteralBoolean, *ast.LiteralNull, *ast.LiteralNumber, *ast.LiteralString:
+	case *ast.Array, *ast.Parens, *ast.Unary:
+		for _, c := range toolutils.Children(node) {
+			if !isSimple(c) {
+				return false
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+func TestSameError(t *testing.T) {
+	trace := []jsonnet.TraceFrame{{Name: "double", Loc: ast.LocationRange{Begin: ast.Location{Line: 2, Column: 3}}}}
+	raised := jsonnet.RuntimeError{Msg: "Unexpected type string", StackTrace: trace}
+	for _, tc := range []struct {
+		name string
+		b    error
+		want bool
+	}{
+		{"copy", jsonnet.RuntimeError{Msg: raised.Msg, StackTrace: append([]jsonnet.TraceFrame{}, trace...)}, true},
+		{"message", jsonnet.RuntimeError{Msg: "Unexpected type number", StackTrace: trace}, false},
+		{"location", jsonnet.RuntimeError{Msg: raised.Msg, StackTrace: []jsonnet.TraceFrame{{Name: "double", Loc: ast.LocationRange{Begin: ast.Location{Line: 3, Column: 1}}}}}, false},
+		{"nil", nil, false},
+	} {
+		if got := sameError(raised, tc.b); got != tc.want {
+			t.Errorf("%s: sameError = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestShouldStopPropagatingError(t *testing.T) {
+	src := `local double(x) =
+  x * 2;
+{ a: [double('a')] }
+`
+	for _, tc := range []struct {
+		filter string
+		want   int
+	}{
+		{exceptionFilterRuntime, 1},
+		{exceptionFilterError, 0},
+	} {
+		t.Run(tc.filter, func(t *testing.T) {
+			e := newExceptionBreakpoints()
+			e.set(map[string]*regexp.Regexp{tc.filter: nil})
+			dbg := jsonnet.MakeDebugger()
+			dbg.Launch(writeFile(t, src), src, nil)
+			stops, reports := 0, 0
+			for {
+				ev := <-dbg.Events()
+				stop, ok := ev.(*jsonnet.DebugEventStop)
+				if !ok {
+					break
+				}
+				reports++
+				if _, ok := e.shouldStop(stop); ok {
+					stops++
+				}
+				dbg.Continue()
+			}
+			if reports < 2 {
+				t.Fatalf("the error was reported %d times, want it to propagate", reports)
+			}
+			if stops != tc.want {
+				t.Errorf("stopped %d times, want %d", stops, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/go-jsonnet"
+)
+
+// frameSelector runs inspections of the debugger in the environment of a
+// stack frame. Selecting a frame other than the innermost one temporarily
+// changes the stack, so inspections are serialized.
+type frameSelector struct {
+	mu sync.Mutex
+}
+
+// in runs f with frame, an index into stackTrace, as the environment
+// variables are looked up in. Negative frames select the innermost one. f is
+// passed the full stack trace, as stackTrace only lists the frames
+// up to the selected one while f runs.
+func (s *frameSelector) in(dbg *jsonnet.Debugger, frame int, f func(trace []jsonnet.TraceFrame)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trace := stackTrace(dbg)
+	if frame >= len(trace) {
+		return fmt.Errorf("no stack frame %d", frame)
+	}
+	if frame >= 0 && frame < len(trace)-1 {
+		restore, err := truncateStack(dbg, frame)
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+	f(trace)
+	return nil
+}
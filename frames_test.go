@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-dap"
+	"github.com/google/go-jsonnet"
+)
+
+const nestedCalls = `local outer(a) =
+  local inner(b) =
+    b * 2;
+  inner(a + 1);
+outer(10)
+`
+
+func TestSelectFrame(t *testing.T) {
+	dbg := stopAt(t, nestedCalls, 3, -1)
+	r := &ReplDebugger{dbg: dbg}
+	eval := func(expr string) string {
+		t.Helper()
+		var out string
+		r.inFrame(func([]jsonnet.TraceFrame) {
+			v, err := evaluate(dbg, expr)
+			if err != nil {
+				out = "error"
+				return
+			}
+			out = v.String()
+		})
+		return out
+	}
+	for _, step := range []struct {
+		command []string
+		frame   int
+		expr    string
+		want    string
+	}{
+		{command: []string{"frame"}, frame: 0, expr: "b", want: "11"},
+		{command: []string{"up"}, frame: 1, expr: "a", want: "10"},
+		// The variables of the inner frame are out of scope
+		{frame: 1, expr: "b", want: "error"},
+		{command: []string{"down"}, frame: 0, expr: "b", want: "11"},
+		{command: []string{"frame", "1"}, frame: 1, expr: "a + 1", want: "11"},
+		// Out of range frames keep the selected one
+		{command: []string{"up", "100"}, frame: 1, expr: "a", want: "10"},
+		{command: []string{"down", "2"}, frame: 1, expr: "a", want: "10"},
+		{command: []string{"frame", "0"}, frame: 0, expr: "b * 2", want: "22"},
+	} {
+		if len(step.command) > 0 {
+			r.selectFrame(step.command)
+		}
+		if r.frame != step.frame {
+			t.Fatalf("%q: selected frame %d, want %d", step.command, r.frame, step.frame)
+		}
+		if got := eval(step.expr); got != step.want {
+			t.Errorf("%q: %s = %s in frame %d, want %s", step.command, step.expr, got, r.frame, step.want)
+		}
+	}
+	finish(t, dbg)
+}
+
+func TestFrameScopedRequests(t *testing.T) {
+	c := launchSession(t, nestedCalls, func(c *testClient, path string) {
+		c.setBreakpoints(path, dap.SourceBreakpoint{Line: 3})
+	})
+	await[*dap.StoppedEvent](c)
+	c.send("stackTrace", dap.StackTraceArguments{ThreadId: 1})
+	frames := await[*dap.StackTraceResponse](c).Body.StackFrames
+	if len(frames) < 2 || frames[0].Line != 3 || frames[1].Line != 4 {
+		t.Fatalf("got stack frames %+v, want inner on line 3 called from line 4", frames)
+	}
+	evaluate := func(frame dap.StackFrame, expr string) string {
+		t.Helper()
+		c.send("evaluate", dap.EvaluateArguments{Expression: expr, FrameId: frame.Id, Context: "repl"})
+		return await[*dap.EvaluateResponse](c).Body.Result
+	}
+	if got := evaluate(frames[0], "b"); got != "11" {
+		t.Errorf("b = %s in the inner frame, want 11", got)
+	}
+	if got := evaluate(frames[1], "a * 3"); got != "30" {
+		t.Errorf("a * 3 = %s in the outer frame, want 30", got)
+	}
+	c.send("evaluate", dap.EvaluateArguments{Expression: "b", FrameId: frames[1].Id, Context: "repl"})
+	if e := await[*dap.ErrorResponse](c); e.Command != "evaluate" {
+		t.Errorf("got an error for %s, want evaluating b in the outer frame to fail", e.Command)
+	}
+	c.send("scopes", dap.ScopesArguments{FrameId: frames[1].Id})
+	var arguments dap.Scope
+	for _, s := range await[*dap.ScopesResponse](c).Body.Scopes {
+		if s.Name == "Arguments" {
+			arguments = s
+		}
+	}
+	if got := c.variables(arguments.VariablesReference); len(got) != 1 || got["a"].Value != "10" {
+		t.Errorf("got arguments %v in the outer frame, want a = 10", got)
+	}
+	// The innermost frame is back in place after inspecting another one
+	if got := c.evaluate("b"); got != "11" {
+		t.Errorf("b = %s after inspecting the outer frame, want 11", got)
+	}
+	c.finish()
+}
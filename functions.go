@@ -0,0 +1,321 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+)
+
+// functionDefinition is where the debugger has to stop for a function
+// breakpoint.
+type functionDefinition struct {
+	// name is the dotted path the function is defined under, such as
+	// `util.container` for a method of an object in the field `util`.
+	name string
+	file string
+	// at is the body of the function, or a call for std functions, which
+	// have no Jsonnet body.
+	at   ast.LocationRange
+	call bool
+	// literal is set for functions returning a literal, the debugger never
+	// stops at their body.
+	literal bool
+}
+
+// stdCallNote tells where breakpoints on std functions stop.
+const stdCallNote = "std functions stop where they are called as std.<name>(...), before the arguments are evaluated or bound to the parameters. Calls under another name, e.g. a local bound to the function, or passed as a value are not found"
+
+// fileRoot is the root of the paths into the value of a file, which are
+// bound to the names the file is imported under.
+const fileRoot = "<file>"
+
+// functionPaths are the paths a function can be referenced by. Absolute
+// paths start with `$`, the name of a local or fileRoot. The relative path
+// leads from the closest local or function result to the function.
+type functionPaths struct {
+	absolute [][]string
+	relative []string
+	// inObject is set inside an object, where `$` is already bound.
+	inObject bool
+	// result is set for the nodes the value of the file is made of.
+	result bool
+}
+
+// field returns the paths of the field name of an object with paths p.
+func (p functionPaths) field(name string) functionPaths {
+	out := functionPaths{relative: appendPath(p.relative, name), inObject: true}
+	for _, path := range p.absolute {
+		out.absolute = append(out.absolute, appendPath(path, name))
+	}
+	return out
+}
+
+// binding returns the paths of something bound to the local name.
+func (p functionPaths) binding(name string) functionPaths {
+	return functionPaths{absolute: [][]string{{name}}, relative: []string{name}, inObject: p.inObject}
+}
+
+func appendPath(path []string, name string) []string {
+	return append(path[:len(path):len(path)], name)
+}
+
+// functionEntry is a function found in a file, before resolving imports.
+type functionEntry struct {
+	file  string
+	paths functionPaths
+	fn    *ast.Function
+}
+
+// importBinding is an import bound to the absolute paths in file.
+type importBinding struct {
+	file     string
+	imported string
+	paths    [][]string
+}
+
+// findFunctions resolves a function breakpoint to all matching definitions
+// in files. Names are written like references in Jsonnet code, e.g.
+// `makeDeployment`, `$.util.container` or `lib.container`. Names starting
+// with `$` or with a local, including one bound to an import, have to match
+// the full path of a definition. Other names match definitions they are a
+// suffix of. `std.<function>` matches every call of that std function.
+func findFunctions(files []*programFile, name string) []functionDefinition {
+	segments := splitFunctionName(name)
+	if len(segments) == 0 {
+		return nil
+	}
+	if len(segments) == 2 && segments[0] == "std" {
+		return findStdCalls(files, segments[1])
+	}
+	var entries []functionEntry
+	var imports []importBinding
+	locals := map[string]bool{}
+	for _, f := range files {
+		c := &functionCollector{file: f, locals: locals}
+		c.collect(f.node, functionPaths{result: true})
+		entries = append(entries, c.functions...)
+		imports = append(imports, c.imports...)
+	}
+	aliases := importAliases(imports, len(files))
+	absolute := segments[0] == "$" || locals[segments[0]]
+	if segments[0] == "self" {
+		segments = segments[1:]
+	}
+	defs := []functionDefinition{}
+	for _, e := range entries {
+		if e.fn.Body == nil || e.fn.Body.Loc().File == nil {
+			continue
+		}
+		var path []string
+		if absolute {
+			for _, p := range expandPaths(e.paths.absolute, aliases[e.file]) {
+				if slices.Equal(p, segments) {
+					path = p
+					break
+				}
+			}
+		} else if isSuffix(segments, e.paths.relative) {
+			path = e.paths.relative
+		}
+		if path == nil {
+			continue
+		}
+		def := functionDefinition{
+			name: strings.Join(path, "."),
+			file: e.file,
+			at:   *e.fn.Body.Loc(),
+		}
+		switch e.fn.Body.(type) {
+		case *ast.LiteralNull, *ast.LiteralNumber, *ast.LiteralString, *ast.LiteralBoolean:
+			def.literal = true
+		}
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+func splitFunctionName(name string) []string {
+	name = strings.TrimSuffix(strings.TrimSpace(name), "()")
+	if name == "" {
+		return nil
+	}
+	segments := strings.Split(name, ".")
+	if len(segments) == 1 && (segments[0] == "$" || segments[0] == "self") {
+		return nil
+	}
+	return segments
+}
+
+// isSuffix reports whether suffix is a suffix of path.
+func isSuffix(suffix, path []string) bool {
+	if len(suffix) == 0 || len(suffix) > len(path) {
+		return false
+	}
+	offset := len(path) - len(suffix)
+	for i, s := range suffix {
+		if path[offset+i] != s {
+			return false
+		}
+	}
+	return true
+}
+
+// importAliases returns the absolute paths the value of each file is bound
+// to. Imports of imports are followed at most depth times, which is enough
+// for any chain without cycles.
+func importAliases(imports []importBinding, depth int) map[string][][]string {
+	aliases := map[string][][]string{}
+	seen := map[string]bool{}
+	for i := 0; i < depth; i++ {
+		changed := false
+		for _, imp := range imports {
+			for _, p := range expandPaths(imp.paths, aliases[imp.file]) {
+				key := imp.imported + "\x00" + strings.Join(p, ".")
+				if p[0] == fileRoot || seen[key] {
+					continue
+				}
+				seen[key] = true
+				aliases[imp.imported] = append(aliases[imp.imported], p)
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return aliases
+}
+
+// expandPaths replaces fileRoot at the start of paths by each of the paths
+// the file is imported under.
+func expandPaths(paths, aliases [][]string) [][]string {
+	var out [][]string
+	for _, p := range paths {
+		if p[0] != fileRoot {
+			out = append(out, p)
+			continue
+		}
+		for _, a := range aliases {
+			out = append(out, append(a[:len(a):len(a)], p[1:]...))
+		}
+	}
+	return out
+}
+
+// functionCollector finds the functions bound to a local or an object field
+// in a file, along with the paths they can be referenced by.
+type functionCollector struct {
+	file      *programFile
+	locals    map[string]bool
+	functions []functionEntry
+	imports   []importBinding
+}
+
+func (c *functionCollector) collect(node ast.Node, paths functionPaths) {
+	switch n := node.(type) {
+	case nil:
+		return
+	case *ast.Import:
+		if imported, ok := c.file.imports[n.File.Value]; ok && len(paths.absolute) > 0 {
+			c.imports = append(c.imports, importBinding{file: c.file.path, imported: imported, paths: paths.absolute})
+		}
+		return
+	case *ast.Local:
+		for _, b := range n.Binds {
+			c.locals[string(b.Variable)] = true
+			c.binding(b.Body, paths.binding(string(b.Variable)))
+		}
+		c.collect(n.Body, paths)
+		return
+	case *ast.DesugaredObject:
+		if !paths.inObject {
+			// The outermost object is bound to $, and is the value of the
+			// file if the file evaluates to it
+			paths.absolute = append(paths.absolute[:len(paths.absolute):len(paths.absolute)], []string{"$"})
+			if paths.result {
+				paths.absolute = append(paths.absolute, []string{fileRoot})
+			}
+		}
+		paths.inObject = true
+		for _, b := range n.Locals {
+			c.locals[string(b.Variable)] = true
+			c.binding(b.Body, paths.binding(string(b.Variable)))
+		}
+		for _, field := range n.Fields {
+			name, ok := field.Name.(*ast.LiteralString)
+			if !ok {
+				c.collect(field.Name, functionPaths{inObject: true})
+				c.collect(field.Body, functionPaths{inObject: true})
+				continue
+			}
+			c.binding(field.Body, paths.field(name.Value))
+		}
+		for _, a := range n.Asserts {
+			c.collect(a, functionPaths{inObject: true})
+		}
+		return
+	case *ast.Binary:
+		// Objects extended with + still make up the value of the file
+		c.collect(n.Left, paths)
+		c.collect(n.Right, paths)
+		return
+	case *ast.Conditional:
+		c.collect(n.Cond, functionPaths{inObject: paths.inObject})
+		c.collect(n.BranchTrue, paths)
+		c.collect(n.BranchFalse, paths)
+		return
+	case *ast.Function:
+		// Names inside a function are relative to its result
+		for _, p := range n.Parameters {
+			c.collect(p.DefaultArg, functionPaths{inObject: paths.inObject})
+		}
+		c.collect(n.Body, functionPaths{inObject: paths.inObject})
+		return
+	}
+	// The value of other nodes is computed from their children, which may
+	// still be referenced through the same paths, e.g. the objects in
+	// `base + { f(x): x }`.
+	paths.result = false
+	for _, ch := range toolutils.Children(node) {
+		c.collect(ch, paths)
+	}
+}
+
+func (c *functionCollector) binding(body ast.Node, paths functionPaths) {
+	if fn, ok := body.(*ast.Function); ok {
+		c.functions = append(c.functions, functionEntry{file: c.file.path, paths: paths, fn: fn})
+	}
+	c.collect(body, paths)
+}
+
+// findStdCalls returns all call sites of std.<name>.
+func findStdCalls(files []*programFile, name string) []functionDefinition {
+	defs := []functionDefinition{}
+	for _, f := range files {
+		walk(f.node, func(n ast.Node) {
+			apply, ok := n.(*ast.Apply)
+			if !ok || apply.Loc().File == nil {
+				return
+			}
+			index, ok := apply.Target.(*ast.Index)
+			if !ok {
+				return
+			}
+			target, ok := index.Target.(*ast.Var)
+			if !ok || (target.Id != "std" && target.Id != "$std") {
+				return
+			}
+			if fn, ok := index.Index.(*ast.LiteralString); ok && fn.Value == name {
+				defs = append(defs, functionDefinition{
+					name: "std." + name,
+					file: f.path,
+					at:   *apply.Loc(),
+					call: true,
+				})
+			}
+		})
+	}
+	return defs
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeFiles writes files, by name, to a directory and resolves the program
+// starting at main.jsonnet.
+func writeFiles(t *testing.T, files map[string]string) (string, []*programFile) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filename := filepath.Join(dir, "main.jsonnet")
+	program, err := resolveProgram(filename, files["main.jsonnet"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename, program
+}
+
+func TestFindFunctions(t *testing.T) {
+	_, program := writeFiles(t, map[string]string{
+		"main.jsonnet": `local lib = import 'lib.libsonnet';
+local other = { container(name): name };
+local makeDeployment(name) = { containers: [lib.container(name)] };
+{
+  util: { container(name):: other.container(name) },
+  deployment: makeDeployment('app'),
+  version: lib.version(),
+}
+`,
+		"lib.libsonnet": `{
+  container(name):: { name: name },
+  util: { container(name):: { image: name } },
+  version():: 'v1',
+}
+`,
+	})
+	for _, test := range []struct {
+		name string
+		want []string
+	}{
+		{name: "makeDeployment", want: []string{"main.jsonnet:3 makeDeployment"}},
+		{name: "makeDeployment()", want: []string{"main.jsonnet:3 makeDeployment"}},
+		{name: "lib.container", want: []string{"lib.libsonnet:2 lib.container"}},
+		{name: "other.container", want: []string{"main.jsonnet:2 other.container"}},
+		{name: "lib.util.container", want: []string{"lib.libsonnet:3 lib.util.container"}},
+		{name: "$.util.container", want: []string{"lib.libsonnet:3 $.util.container", "main.jsonnet:5 $.util.container"}},
+		{name: "self.util.container", want: []string{"lib.libsonnet:3 util.container", "main.jsonnet:5 util.container"}},
+		{name: "util.container", want: []string{"lib.libsonnet:3 util.container", "main.jsonnet:5 util.container"}},
+		{name: "container", want: []string{
+			"lib.libsonnet:2 container",
+			"lib.libsonnet:3 util.container",
+			"main.jsonnet:2 other.container",
+			"main.jsonnet:5 util.container",
+		}},
+		{name: "lib.version", want: []string{"lib.libsonnet:4 lib.version (literal)"}},
+		{name: "missing.container", want: nil},
+		{name: "$", want: nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, def := range findFunctions(program, test.name) {
+				s := fmt.Sprintf("%s:%d %s", filepath.Base(def.file), def.at.Begin.Line, def.name)
+				if def.literal {
+					s += " (literal)"
+				}
+				got = append(got, s)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFindFunctionsImportChain(t *testing.T) {
+	_, program := writeFiles(t, map[string]string{
+		"main.jsonnet":   "local k = import 'k.libsonnet';\nk.apps.deployment('app')\n",
+		"k.libsonnet":    "{ apps: import 'apps.libsonnet' }\n",
+		"apps.libsonnet": "local base = { labels: {} };\nbase + {\n  deployment(name): { name: name },\n}\n",
+	})
+	defs := findFunctions(program, "k.apps.deployment")
+	if len(defs) != 1 || filepath.Base(defs[0].file) != "apps.libsonnet" || defs[0].at.Begin.Line != 3 {
+		t.Fatalf("got %+v, want the deployment function in apps.libsonnet", defs)
+	}
+	if defs := findFunctions(program, "k.deployment"); len(defs) != 0 {
+		t.Errorf("got %+v for a path that skips a field", defs)
+	}
+}
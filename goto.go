@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// gotoTargets are the locations offered by the last goto targets request.
+// Jsonnet has no side effects, so going to a target does not jump there but
+// runs until the evaluation reaches it.
+type gotoTargets struct {
+	mu   sync.Mutex
+	file string
+	// targets are the locations in file, their ids are their indexes plus
+	// one.
+	targets []*ast.LocationRange
+}
+
+func (g *gotoTargets) set(file string, targets []*ast.LocationRange) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.file = file
+	g.targets = targets
+}
+
+// get returns the file and location of the target id.
+func (g *gotoTargets) get(id int) (string, *ast.LocationRange, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if id < 1 || id > len(g.targets) {
+		return "", nil, fmt.Errorf("Unknown goto target %d", id)
+	}
+	return g.file, g.targets[id-1], nil
+}
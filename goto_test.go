@@ -0,0 +1,55 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/go-dap"
+)
+
+func TestGoto(t *testing.T) {
+	var path string
+	c := launchSession(t, `local f(x) =
+  local y = x + 1;
+  std.max(y * 2, 10);
+[f(1), f(2)]
+`, func(c *testClient, p string) {
+		path = p
+		c.setBreakpoints(path, dap.SourceBreakpoint{Line: 2})
+	})
+	await[*dap.StoppedEvent](c)
+	// The breakpoint would stop again in the second call
+	c.setBreakpoints(path)
+
+	c.send("gotoTargets", dap.GotoTargetsArguments{Source: dap.Source{Path: path}, Line: 3})
+	targets := await[*dap.GotoTargetsResponse](c).Body.Targets
+	var labels []string
+	for _, target := range targets {
+		labels = append(labels, target.Label)
+	}
+	// Literals are left out, the debugger cannot stop at them
+	if want := []string{"std.max(y * 2, 10)", "y * 2"}; !slices.Equal(labels, want) {
+		t.Fatalf("got goto targets %q, want %q", labels, want)
+	}
+	c.send("goto", dap.GotoArguments{ThreadId: 1, TargetId: targets[1].Id})
+	await[*dap.GotoResponse](c)
+	stopped := await[*dap.StoppedEvent](c)
+	if stopped.Body.Reason != "goto" {
+		t.Fatalf("stopped for %s, want goto", stopped.Body.Reason)
+	}
+	if y := c.evaluate("y"); y != "2" {
+		t.Errorf("y = %s at the target, want 2", y)
+	}
+	// The target is not kept as a breakpoint
+	c.send("continue", dap.ContinueArguments{ThreadId: 1})
+	await[*dap.TerminatedEvent](c)
+	for _, msg := range c.pending {
+		if _, ok := msg.(*dap.StoppedEvent); ok {
+			t.Error("stopped at the goto target again")
+		}
+	}
+	c.send("goto", dap.GotoArguments{ThreadId: 1, TargetId: 100})
+	if e := await[*dap.ErrorResponse](c); e.Command != "goto" {
+		t.Errorf("got an error for %s, want one for the unknown goto target", e.Command)
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// hookedJsonnetVersion is the version of go-jsonnet the hooks are written
+// against. They overwrite unexported function pointers of its VM, which
+// would corrupt memory if their layout changed, so they are not installed
+// with any other version. Check the hooks still match the internals of
+// go-jsonnet before updating it.
+const hookedJsonnetVersion = "v0.20.1-0.20240611134004-2b4d7535f540"
+
+// rawValue has the layout of the value interface of the interpreter.
+type rawValue struct {
+	tab, data unsafe.Pointer
+}
+
+// States of evalHooks.pausing.
+const (
+	pauseNone int32 = iota
+	// pauseRequested is set by pause until the interpreter sees it.
+	pauseRequested
+	// pauseDelivered is set once the debugger was made to stop at the next
+	// node, until the stop is reported.
+	pauseDelivered
+)
+
+// evalHooks wraps the evaluation hooks the debugger installs on its VM, for
+// what has to follow the evaluation node by node. They run for every node,
+// so the hooks of the debugger are called directly instead of through
+// reflection, with the interpreter passed as an unsafe.Pointer.
+//
+// The hooks run on the goroutine of the interpreter, which also runs them
+// for the evaluations of the frontend while the debugger is stopped. The
+// debugger skips those, and so do the wrappers.
+type evalHooks struct {
+	pre  func(unsafe.Pointer, ast.Node)
+	post func(unsafe.Pointer, ast.Node, rawValue, error)
+	// skip and singleStep are the fields of the debugger, which are only
+	// accessed by the interpreter or while it waits for the frontend.
+	skip, singleStep *bool
+	installed        atomic.Bool
+	dbg              *jsonnet.Debugger
+
+	// stackOffset is the offset of the frames of the call stack in the
+	// interpreter, and cleanEnvOffset the one of the flag marking calls in
+	// the frames.
+	stackOffset, cleanEnvOffset uintptr
+
+	// pausing holds the pause state, the only one changed while the
+	// interpreter runs.
+	pausing atomic.Int32
+	// restarter tracks the frames to restart, if enabled.
+	restarter *frameRestarter
+	// watch is the frame being stepped out of, and returned what it
+	// returned once it did.
+	watch    atomic.Pointer[frameWatch]
+	returned atomic.Pointer[frameReturn]
+	// valueType is the value interface of the interpreter.
+	valueType reflect.Type
+
+	// literals are the positions of the literals to stop at as if they had
+	// a breakpoint, the debugger skips literals. cont and breakOnNode are the
+	// fields of the debugger its hooks wait on to continue.
+	literals    atomic.Pointer[map[literalPosition]bool]
+	cont        reflect.Value
+	breakOnNode reflect.Value
+
+	// exposed are the nodes binding $ whose nested nodes were made to
+	// capture it, see exposeDollar.
+	exposed map[ast.Node]bool
+}
+
+// literalPosition identifies a literal by the diagnostic name of its file
+// and where it starts, as the debugger does for breakpoints.
+type literalPosition struct {
+	file  string
+	begin ast.Location
+}
+
+// frameWatch follows a call of the interpreter stack until it returns. It
+// is only accessed by the interpreter once the evaluation resumes.
+type frameWatch struct {
+	// frame is the *callFrame at index of the stack.
+	frame unsafe.Pointer
+	index int
+	// stop makes the debugger stop once the call returned.
+	stop bool
+	// result is the last value evaluated directly in the call, which is
+	// its result once it returned.
+	result rawValue
+}
+
+// frameReturn is the value a watched call returned.
+type frameReturn struct {
+	value rawValue
+}
+
+// install wraps the evaluation hooks of the VM of dbg. It must be called
+// before the evaluation starts.
+func (h *evalHooks) install(dbg *jsonnet.Debugger) error {
+	if err := checkJsonnetVersion(); err != nil {
+		return err
+	}
+	vm, err := debuggerVM(dbg)
+	if err != nil {
+		return err
+	}
+	hook := reflect.ValueOf(vm).Elem().FieldByName("EvalHook")
+	if !hook.IsValid() {
+		return fmt.Errorf("unsupported version of go-jsonnet: VM.EvalHook is missing")
+	}
+	pre, err := unexportedField(hook, "pre", reflect.Func)
+	if err != nil {
+		return err
+	}
+	post, err := unexportedField(hook, "post", reflect.Func)
+	if err != nil {
+		return err
+	}
+	if err := checkHookTypes(pre.Type(), post.Type()); err != nil {
+		return err
+	}
+	skip, err := debuggerField(dbg, "skip", reflect.Bool)
+	if err != nil {
+		return err
+	}
+	singleStep, err := debuggerField(dbg, "singleStep", reflect.Bool)
+	if err != nil {
+		return err
+	}
+	if err := h.layout(pre.Type().In(0).Elem()); err != nil {
+		return err
+	}
+	cont, err := debuggerField(dbg, "cont", reflect.Chan)
+	if err != nil {
+		return err
+	}
+	if until, ok := cont.Type().Elem().FieldByName("until"); !ok || until.Type.Kind() != reflect.Pointer {
+		return fmt.Errorf("unsupported version of go-jsonnet: continuationEvent.until is not a pointer")
+	}
+	breakOnNode, err := debuggerField(dbg, "breakOnNode", reflect.Interface)
+	if err != nil {
+		return err
+	}
+	h.cont, h.breakOnNode = cont, breakOnNode
+	h.exposed = map[ast.Node]bool{}
+	h.dbg = dbg
+	h.valueType = post.Type().In(2)
+	h.skip = (*bool)(unsafe.Pointer(skip.UnsafeAddr()))
+	h.singleStep = (*bool)(unsafe.Pointer(singleStep.UnsafeAddr()))
+
+	prePtr := (*func(unsafe.Pointer, ast.Node))(unsafe.Pointer(pre.UnsafeAddr()))
+	postPtr := (*func(unsafe.Pointer, ast.Node, rawValue, error))(unsafe.Pointer(post.UnsafeAddr()))
+	h.pre, h.post = *prePtr, *postPtr
+	*prePtr = h.preHook
+	*postPtr = h.postHook
+	h.installed.Store(true)
+	return nil
+}
+
+// checkHookTypes checks the hooks have the signatures install casts them to.
+func checkHookTypes(pre, post reflect.Type) error {
+	node := reflect.TypeOf((*ast.Node)(nil)).Elem()
+	errType := reflect.TypeOf((*error)(nil)).Elem()
+	isInterpreter := func(t reflect.Type) bool {
+		return t.Kind() == reflect.Pointer && t.Elem().Name() == "interpreter"
+	}
+	if pre.NumIn() != 2 || pre.NumOut() != 0 || !isInterpreter(pre.In(0)) || pre.In(1) != node {
+		return fmt.Errorf("unsupported version of go-jsonnet: EvalHook.pre is a %s", pre)
+	}
+	if post.NumIn() != 4 || post.NumOut() != 0 || !isInterpreter(post.In(0)) || post.In(1) != node ||
+		post.In(2).Kind() != reflect.Interface || post.In(2).NumMethod() == 0 ||
+		post.In(2).Size() != unsafe.Sizeof(rawValue{}) || post.In(3) != errType {
+		return fmt.Errorf("unsupported version of go-jsonnet: EvalHook.post is a %s", post)
+	}
+	return nil
+}
+
+// checkJsonnetVersion checks the program is built with the version of
+// go-jsonnet the hooks are written against, see hookedJsonnetVersion.
+func checkJsonnetVersion() error {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return fmt.Errorf("unsupported version of go-jsonnet: the version the program is built with is unknown")
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != "github.com/google/go-jsonnet" {
+			continue
+		}
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		if dep.Version != hookedJsonnetVersion {
+			return fmt.Errorf("unsupported version of go-jsonnet: %s, the evaluation hooks need %s", dep.Version, hookedJsonnetVersion)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported version of go-jsonnet: the program is not built with it as a module")
+}
+
+// layout computes the offsets of the fields of the interpreter the hooks
+// read, mirroring callStack and callFrame.
+func (h *evalHooks) layout(interp reflect.Type) error {
+	stack, ok := interp.FieldByName("stack")
+	if !ok || stack.Type.Kind() != reflect.Struct {
+		return fmt.Errorf("unsupported version of go-jsonnet: interpreter.stack is not a struct")
+	}
+	frames, ok := stack.Type.FieldByName("stack")
+	if !ok || frames.Type.Kind() != reflect.Slice || frames.Type.Elem().Kind() != reflect.Pointer {
+		return fmt.Errorf("unsupported version of go-jsonnet: callStack.stack is not a slice of pointers")
+	}
+	cleanEnv, ok := frames.Type.Elem().Elem().FieldByName("cleanEnv")
+	if !ok || cleanEnv.Type.Kind() != reflect.Bool {
+		return fmt.Errorf("unsupported version of go-jsonnet: callFrame.cleanEnv is not a bool")
+	}
+	h.stackOffset = stack.Offset + frames.Offset
+	h.cleanEnvOffset = cleanEnv.Offset
+	return nil
+}
+
+// frames returns the *callFrame pointers of the stack of interp.
+func (h *evalHooks) frames(interp unsafe.Pointer) []unsafe.Pointer {
+	return *(*[]unsafe.Pointer)(unsafe.Add(interp, h.stackOffset))
+}
+
+// isCall reports whether frame starts a call, see isCall.
+func (h *evalHooks) isCall(frame unsafe.Pointer) bool {
+	return *(*bool)(unsafe.Add(frame, h.cleanEnvOffset))
+}
+
+func (h *evalHooks) preHook(interp unsafe.Pointer, n ast.Node) {
+	switch n.(type) {
+	case *ast.DesugaredObject, *ast.Local:
+		h.exposeDollar(n)
+	}
+	r := h.restarter
+	if r != nil && !r.beforeHook(h, interp, n) {
+		h.pre(interp, n)
+		return
+	}
+	if !*h.skip {
+		if h.pausing.CompareAndSwap(pauseRequested, pauseDelivered) {
+			*h.singleStep = true
+		}
+		if w := h.watch.Load(); w != nil {
+			h.checkWatch(w, h.frames(interp))
+		}
+	}
+	h.pre(interp, n)
+	if literals := h.literals.Load(); literals != nil && !*h.skip {
+		h.checkLiteral(*literals, n)
+	}
+	if r != nil {
+		r.afterHook(h)
+	}
+}
+
+func (h *evalHooks) postHook(interp unsafe.Pointer, n ast.Node, v rawValue, err error) {
+	r := h.restarter
+	if r != nil && !r.beforeHook(h, interp, nil) {
+		h.post(interp, n, v, err)
+		return
+	}
+	if w := h.watch.Load(); w != nil && !*h.skip {
+		frames := h.frames(interp)
+		if len(frames) == w.index+1 && frames[w.index] == w.frame {
+			w.result = v
+		} else {
+			h.checkWatch(w, frames)
+		}
+	}
+	h.post(interp, n, v, err)
+	if r != nil {
+		r.afterHook(h)
+	}
+}
+
+// exposeDollar makes the nodes in the scope of n capture $ if n binds it,
+// before any of them is evaluated. Closures only capture the variables they
+// use, so $ could not be looked up in objects nested in the outermost one
+// otherwise. The outermost object binds $ as one of its locals, and object
+// comprehensions in a local of their field.
+func (h *evalHooks) exposeDollar(n ast.Node) {
+	var scope []ast.Node
+	switch n := n.(type) {
+	case *ast.DesugaredObject:
+		if !bindsDollar(n.Locals) {
+			return
+		}
+		for _, f := range n.Fields {
+			scope = append(scope, f.Body)
+		}
+		for _, l := range n.Locals {
+			scope = append(scope, l.Body)
+		}
+		scope = append(scope, n.Asserts...)
+	case *ast.Local:
+		if !bindsDollar(n.Binds) {
+			return
+		}
+		for _, b := range n.Binds {
+			scope = append(scope, b.Body)
+		}
+		scope = append(scope, n.Body)
+	}
+	// The standard library does not use $, object comprehensions bind it in
+	// a local without location
+	loc := n.Loc()
+	if h.exposed[n] || loc.File != nil && loc.File.DiagnosticFileName == "<std>" {
+		return
+	}
+	h.exposed[n] = true
+	for _, s := range scope {
+		walk(s, func(c ast.Node) {
+			free := c.FreeVariables()
+			for _, id := range free {
+				if id == "$" {
+					return
+				}
+			}
+			// The free variables may be shared with other nodes
+			c.SetFreeVariables(append(append(ast.Identifiers{}, free...), "$"))
+		})
+	}
+}
+
+func bindsDollar(binds ast.LocalBinds) bool {
+	for _, b := range binds {
+		if b.Variable == "$" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWatch ends watching the call of w once it is no longer on the stack
+// frames.
+func (h *evalHooks) checkWatch(w *frameWatch, frames []unsafe.Pointer) {
+	if w.index < len(frames) && frames[w.index] == w.frame {
+		return
+	}
+	h.watch.Store(nil)
+	h.returned.Store(&frameReturn{value: w.result})
+	if w.stop {
+		*h.singleStep = true
+	}
+}
+
+// watchFrame follows the innermost call of the stack of the stopped
+// debugger until it returns, see returnValue. If stop is set, the debugger
+// stops at the first node evaluated after the call returned.
+func (h *evalHooks) watchFrame(dbg *jsonnet.Debugger, stop bool) error {
+	if !h.installed.Load() {
+		return fmt.Errorf("Stepping out is not supported with this version of go-jsonnet")
+	}
+	_, frames, err := callStack(dbg)
+	if err != nil {
+		return err
+	}
+	h.returned.Store(nil)
+	for k := frames.Len() - 1; k >= 0; k-- {
+		call, err := isCall(frames.Index(k))
+		if err != nil {
+			return err
+		}
+		if call {
+			h.watch.Store(&frameWatch{frame: framePointer(frames, k), index: k, stop: stop})
+			return nil
+		}
+	}
+	return fmt.Errorf("the evaluation has not started yet")
+}
+
+// unwatch stops following the call of watchFrame, and forgets the value
+// it returned.
+func (h *evalHooks) unwatch() {
+	h.watch.Store(nil)
+	h.returned.Store(nil)
+}
+
+// returnValue returns the value of the call followed since watchFrame,
+// valid once it returned.
+func (h *evalHooks) returnValue() reflect.Value {
+	r := h.returned.Load()
+	if r == nil || r.value.tab == nil {
+		return reflect.Value{}
+	}
+	return reflect.NewAt(h.valueType, unsafe.Pointer(&r.value)).Elem().Elem()
+}
+
+// breakAtLiterals makes the debugger stop at the literals starting at
+// positions, like at breakpoints, replacing the previous ones. Breakpoints
+// can be set on literals, but the debugger never stops there.
+func (h *evalHooks) breakAtLiterals(positions []ast.LocationRange) error {
+	if len(positions) == 0 {
+		h.literals.Store(nil)
+		return nil
+	}
+	if !h.installed.Load() {
+		return fmt.Errorf("Stopping at literals is not supported with this version of go-jsonnet")
+	}
+	literals := map[literalPosition]bool{}
+	for _, p := range positions {
+		literals[literalPosition{file: string(p.File.DiagnosticFileName), begin: p.Begin}] = true
+	}
+	h.literals.Store(&literals)
+	return nil
+}
+
+// checkLiteral stops at n if it is one of the literals, the way the
+// debugger stops at breakpoints, and waits for the frontend to continue.
+func (h *evalHooks) checkLiteral(literals map[literalPosition]bool, n ast.Node) {
+	switch n.(type) {
+	case *ast.LiteralNull, *ast.LiteralNumber, *ast.LiteralString, *ast.LiteralBoolean:
+	default:
+		return
+	}
+	loc := n.Loc()
+	if loc.File == nil || !literals[literalPosition{file: string(loc.File.DiagnosticFileName), begin: loc.Begin}] {
+		return
+	}
+	h.dbg.Events() <- &jsonnet.DebugEventStop{
+		Reason:     jsonnet.StopReasonBreakpoint,
+		Breakpoint: loc.Begin.String(),
+		Current:    n,
+	}
+	c, _ := h.cont.Recv()
+	event := reflect.New(c.Type()).Elem()
+	event.Set(c)
+	// the type of until was checked by install
+	until, _ := unexportedField(event, "until", reflect.Pointer)
+	if !until.IsNil() {
+		h.breakOnNode.Set(until.Elem())
+	}
+}
+
+// pause interrupts the running evaluation. The debugger then stops at the
+// next node as if stepping.
+func (h *evalHooks) pause() error {
+	if !h.installed.Load() {
+		return fmt.Errorf("Pausing is not supported with this version of go-jsonnet")
+	}
+	h.pausing.CompareAndSwap(pauseNone, pauseRequested)
+	return nil
+}
+
+// paused reports whether a step stop is the result of pause.
+func (h *evalHooks) paused() bool {
+	return h.pausing.CompareAndSwap(pauseDelivered, pauseNone)
+}
+
+// cancelPause drops a pending pause when the debugger stops for another
+// reason first, so it does not stop again right after resuming. It must
+// only be called while the debugger is stopped.
+func (h *evalHooks) cancelPause() {
+	if h.pausing.Swap(pauseNone) == pauseDelivered {
+		*h.singleStep = false
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet"
+)
+
+func TestPause(t *testing.T) {
+	dbg := jsonnet.MakeDebugger()
+	var h evalHooks
+	if err := h.install(dbg); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.pause(); err != nil {
+		t.Fatal(err)
+	}
+	dbg.Launch("main.jsonnet", "local x = 1; x + 1", nil)
+	stop := waitStop(t, dbg)
+	if stop == nil || stop.Reason != jsonnet.StopReasonStep {
+		t.Fatalf("got %+v, want a step stop", stop)
+	}
+	if !h.paused() {
+		t.Error("the stop is not reported as a pause")
+	}
+	if h.paused() {
+		t.Error("the pause is reported twice")
+	}
+	if out := finish(t, dbg); out != "2\n" {
+		t.Errorf("got output %q", out)
+	}
+}
+
+func TestCancelPause(t *testing.T) {
+	var h evalHooks
+	dbg := stopWith(t, "local x = 1;\nx + 1\n", 2, 1, h.install)
+	if err := h.pause(); err != nil {
+		t.Fatal(err)
+	}
+	h.cancelPause()
+	if h.paused() {
+		t.Error("a cancelled pause is reported")
+	}
+	// The program runs to the end without stopping
+	dbg.Continue()
+	if stop := waitStop(t, dbg); stop != nil {
+		t.Errorf("stopped at %v after cancelling the pause", stop.Current.Loc())
+	}
+}
+
+func TestPauseRunning(t *testing.T) {
+	dbg := jsonnet.MakeDebugger()
+	var h evalHooks
+	if err := h.install(dbg); err != nil {
+		t.Fatal(err)
+	}
+	dbg.Launch("main.jsonnet", "std.foldl(function(a, b) a + b, std.range(1, 100000000), 0)", nil)
+	if err := h.pause(); err != nil {
+		t.Fatal(err)
+	}
+	stop := waitStop(t, dbg)
+	if stop == nil || !h.paused() {
+		t.Fatalf("got %+v, want a pause", stop)
+	}
+	dbg.Terminate()
+}
+
+func TestHookedJsonnetVersion(t *testing.T) {
+	// Fails when go-jsonnet is updated, the hooks have to be checked
+	// against the new version before updating hookedJsonnetVersion
+	if err := checkJsonnetVersion(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+)
+
+// hover looks up expr for showing it when hovering over it in the editor.
+// Only variables, self and $ followed by field accesses and array indexes
+// are supported, such as `config.namespace` or `self.items[0]`. Unlike
+// evaluate, it only inspects values that have already been evaluated, so it
+// never raises errors nor changes the state of the program. It returns false
+// if the value is not available.
+func hover(dbg *jsonnet.Debugger, expr string) (*debugValue, bool) {
+	node, _, err := formatter.SnippetToRawAST("<hover>", strings.TrimSpace(expr))
+	if err != nil {
+		return nil, false
+	}
+	return hoverNode(dbg, node)
+}
+
+func hoverNode(dbg *jsonnet.Debugger, node ast.Node) (*debugValue, bool) {
+	v, err := runtimeValue(dbg, node)
+	if err != nil {
+		slog.Debug("unable to inspect value", "err", err)
+	}
+	if !v.IsValid() {
+		return nil, false
+	}
+	inspected, err := inspectValue(v, previewDepth)
+	if err != nil {
+		slog.Debug("unable to inspect value", "err", err)
+		return nil, false
+	}
+	return inspected, true
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-dap"
+)
+
+func TestHoverDoesNotEvaluate(t *testing.T) {
+	c := launchSession(t, `local config = { name: 'app', bad: error 'boom', replicas: 2 };
+local name = config.name;
+std.length(name) +
+  config.replicas
+`, func(c *testClient, path string) {
+		c.setBreakpoints(path, dap.SourceBreakpoint{Line: 4})
+	})
+	await[*dap.StoppedEvent](c)
+	hover := func(expr string) dap.EvaluateResponseBody {
+		t.Helper()
+		c.send("evaluate", dap.EvaluateArguments{Expression: expr, Context: "hover"})
+		return await[*dap.EvaluateResponse](c).Body
+	}
+	config := hover("config")
+	if config.VariablesReference == 0 {
+		t.Fatalf("got %q without a reference to expand it", config.Result)
+	}
+	fields := c.variables(config.VariablesReference)
+	for name, want := range map[string]string{"name": `"app"`, "bad": "<not evaluated>", "replicas": "<not evaluated>"} {
+		if v := fields[name]; v.Value != want || v.Type == "error" || v.VariablesReference != 0 {
+			t.Errorf("%s = %s of type %s, want %s", name, v.Value, v.Type, want)
+		}
+	}
+	if got := hover("config.bad"); got.Result != "" {
+		t.Errorf("hovering an unevaluated field shows %q", got.Result)
+	}
+	// Expanding the fields did not raise the error
+	c.finish()
+}
@@ -0,0 +1,1108 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+)
+
+// The functions in this file reach into the unexported state of
+// jsonnet.Debugger for features its API does not cover yet. They fail with
+// an error rather than panicking if the fields they rely on change.
+
+// debuggerField returns a settable reference to the unexported field name of
+// dbg, checking it has the expected kind.
+func debuggerField(dbg *jsonnet.Debugger, name string, kind reflect.Kind) (reflect.Value, error) {
+	return unexportedField(reflect.ValueOf(dbg).Elem(), name, kind)
+}
+
+// unexportedField returns a settable reference to the unexported field name
+// of the addressable struct v, checking it has the expected kind.
+func unexportedField(v reflect.Value, name string, kind reflect.Kind) (reflect.Value, error) {
+	f := v.FieldByName(name)
+	if !f.IsValid() || f.Kind() != kind {
+		return reflect.Value{}, fmt.Errorf("unsupported version of go-jsonnet: %s.%s is not a %s", v.Type().Name(), name, kind)
+	}
+	return reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem(), nil
+}
+
+// setSingleStep makes the debugger stop at the next node it evaluates, as
+// Debugger.Step does, but without resuming the evaluation. The flag is not
+// synchronized, it must only be set by the interpreter or while the
+// debugger is stopped, see evalHooks.pause for interrupting it.
+func setSingleStep(dbg *jsonnet.Debugger, step bool) error {
+	f, err := debuggerField(dbg, "singleStep", reflect.Bool)
+	if err != nil {
+		return err
+	}
+	f.SetBool(step)
+	return nil
+}
+
+// debuggerVM returns the VM the debugger evaluates the program with, to
+// configure it before calling Debugger.Launch.
+func debuggerVM(dbg *jsonnet.Debugger) (*jsonnet.VM, error) {
+	f, err := debuggerField(dbg, "vm", reflect.Pointer)
+	if err != nil {
+		return nil, err
+	}
+	vm, ok := f.Interface().(*jsonnet.VM)
+	if !ok || vm == nil {
+		return nil, fmt.Errorf("unsupported version of go-jsonnet: Debugger.vm is not a *jsonnet.VM")
+	}
+	return vm, nil
+}
+
+// keepCurrent returns a function restoring the node the debugger is
+// stopped at and the last value it evaluated. Debugger.LookupValue
+// evaluates with the hooks of the debugger, which move it to the nodes
+// being evaluated.
+func keepCurrent(dbg *jsonnet.Debugger) (func(), error) {
+	var fields, saved []reflect.Value
+	for _, name := range []string{"current", "lastEvaluation"} {
+		f, err := debuggerField(dbg, name, reflect.Interface)
+		if err != nil {
+			return nil, err
+		}
+		v := reflect.New(f.Type()).Elem()
+		v.Set(f)
+		fields = append(fields, f)
+		saved = append(saved, v)
+	}
+	return func() {
+		for i, f := range fields {
+			f.Set(saved[i])
+		}
+	}, nil
+}
+
+// callStack returns the call stack of the interpreter and its frames.
+func callStack(dbg *jsonnet.Debugger) (stack, frames reflect.Value, err error) {
+	interp, err := debuggerField(dbg, "interpreter", reflect.Pointer)
+	if err != nil {
+		return stack, frames, err
+	}
+	if interp.IsNil() {
+		return stack, frames, fmt.Errorf("the evaluation has not started")
+	}
+	stack, err = unexportedField(interp.Elem(), "stack", reflect.Struct)
+	if err != nil {
+		return stack, frames, err
+	}
+	frames, err = unexportedField(stack, "stack", reflect.Slice)
+	return stack, frames, err
+}
+
+// isCall reports whether a frame of the call stack starts a clean
+// environment, i.e. is a function call or a thunk. Only these frames are
+// listed in stack traces.
+func isCall(frame reflect.Value) (bool, error) {
+	clean := frame.Elem().FieldByName("cleanEnv")
+	if clean.Kind() != reflect.Bool {
+		return false, fmt.Errorf("unsupported version of go-jsonnet: callFrame.cleanEnv is not a bool")
+	}
+	return clean.Bool(), nil
+}
+
+// framePointer returns the frame at index k of the interpreter stack frames.
+func framePointer(frames reflect.Value, k int) unsafe.Pointer {
+	return unsafe.Pointer(frames.Index(k).Pointer())
+}
+
+// traceLocation returns the location of a trace element.
+func traceLocation(trace reflect.Value) (*ast.LocationRange, error) {
+	loc, err := unexportedField(trace, "loc", reflect.Pointer)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := loc.Interface().(*ast.LocationRange)
+	if !ok {
+		return nil, fmt.Errorf("unsupported version of go-jsonnet: traceElement.loc is not a location")
+	}
+	return l, nil
+}
+
+// stackTrace is Debugger.StackTrace with one frame per call, followed by
+// the current location. When the debugger stops at the first node of a call,
+// Debugger.StackTrace replaces the location the call was made from with the
+// current one, losing the caller's frame.
+func stackTrace(dbg *jsonnet.Debugger) []jsonnet.TraceFrame {
+	trace := dbg.StackTrace()
+	if len(trace) == 0 {
+		return trace
+	}
+	callSite, err := replacedCallSite(dbg)
+	if err != nil {
+		slog.Debug("unable to restore the stack trace", "err", err)
+		return trace
+	}
+	if callSite == nil {
+		return trace
+	}
+	current := trace[len(trace)-1].Loc
+	trace[len(trace)-1].Loc = *callSite
+	return append(trace, jsonnet.TraceFrame{Loc: current})
+}
+
+// replacedCallSite returns the location the innermost call was made from,
+// if Debugger.StackTrace replaced it.
+func replacedCallSite(dbg *jsonnet.Debugger) (*ast.LocationRange, error) {
+	stack, frames, err := callStack(dbg)
+	if err != nil {
+		return nil, err
+	}
+	current, err := unexportedField(stack, "currentTrace", reflect.Struct)
+	if err != nil {
+		return nil, err
+	}
+	if loc, err := traceLocation(current); err != nil || loc != nil {
+		return nil, err
+	}
+	for k := frames.Len() - 1; k >= 0; k-- {
+		call, err := isCall(frames.Index(k))
+		if err != nil {
+			return nil, err
+		}
+		if !call {
+			continue
+		}
+		trace, err := unexportedField(frames.Index(k).Elem(), "trace", reflect.Struct)
+		if err != nil {
+			return nil, err
+		}
+		return traceLocation(trace)
+	}
+	return nil, nil
+}
+
+// truncateStack hides the stack frames above the call of the given frame,
+// numbered like the frames of stackTrace, so variables are looked up in the
+// environment the call was made from. The returned function restores the
+// stack and must be called before the evaluation resumes.
+func truncateStack(dbg *jsonnet.Debugger, frame int) (func(), error) {
+	_, frames, err := callStack(dbg)
+	if err != nil {
+		return nil, err
+	}
+	calls, end := -1, -1
+	for k := 0; k < frames.Len() && end < 0; k++ {
+		call, err := isCall(frames.Index(k))
+		if err != nil {
+			return nil, err
+		}
+		if call {
+			calls++
+			if calls == frame {
+				end = k
+			}
+		}
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("no stack frame %d", frame)
+	}
+	orig := reflect.New(frames.Type()).Elem()
+	orig.Set(frames)
+	// The truncated stack gets its own backing array, as inspecting values
+	// may push frames, which would otherwise overwrite the hidden ones.
+	truncated := reflect.MakeSlice(frames.Type(), end, end)
+	reflect.Copy(truncated, frames.Slice(0, end))
+	frames.Set(truncated)
+	return func() { frames.Set(orig) }, nil
+}
+
+// variableValue returns the value of the variable name of the current
+// environment. The result is invalid if there is no such variable or it has
+// not been evaluated yet.
+func variableValue(dbg *jsonnet.Debugger, name string) (reflect.Value, error) {
+	thunk, err := variableThunk(dbg, name)
+	if err != nil || !thunk.IsValid() {
+		return reflect.Value{}, err
+	}
+	return thunkValue(thunk)
+}
+
+// variableThunk returns the *cachedThunk the variable name of the current
+// environment is bound to, mirroring callStack.lookUpVar. The result is
+// invalid if there is no such variable.
+func variableThunk(dbg *jsonnet.Debugger, name string) (reflect.Value, error) {
+	_, frames, err := callStack(dbg)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	for k := frames.Len() - 1; k >= 0; k-- {
+		upValues, err := frameBindings(frames.Index(k))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if thunk := upValues.MapIndex(reflect.ValueOf(ast.Identifier(name))); thunk.IsValid() {
+			return thunk, nil
+		}
+		if call, err := isCall(frames.Index(k)); err != nil || call {
+			return reflect.Value{}, err
+		}
+	}
+	return reflect.Value{}, nil
+}
+
+// frameBindings returns a settable reference to the variables introduced by
+// a stack frame.
+func frameBindings(frame reflect.Value) (reflect.Value, error) {
+	env, err := unexportedField(frame.Elem(), "env", reflect.Struct)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return unexportedField(env, "upValues", reflect.Map)
+}
+
+// setThunk rebinds thunk to body, which must not reference any variable as
+// it is evaluated in an empty environment. value is the result of body, the
+// thunk evaluates body the next time it is forced if value is invalid.
+func setThunk(thunk reflect.Value, body ast.Node, value reflect.Value) error {
+	if thunk.Kind() != reflect.Pointer || thunk.IsNil() {
+		return fmt.Errorf("unsupported version of go-jsonnet: %s is not a thunk", thunk.Type())
+	}
+	env, err := unexportedField(thunk.Elem(), "env", reflect.Pointer)
+	if err != nil {
+		return err
+	}
+	code, err := unexportedField(thunk.Elem(), "body", reflect.Interface)
+	if err != nil {
+		return err
+	}
+	content, err := unexportedField(thunk.Elem(), "content", reflect.Interface)
+	if err != nil {
+		return err
+	}
+	thunkErr, err := unexportedField(thunk.Elem(), "err", reflect.Interface)
+	if err != nil {
+		return err
+	}
+	env.Set(reflect.New(env.Type().Elem()))
+	code.Set(reflect.ValueOf(body))
+	if value.IsValid() {
+		content.Set(value)
+	} else {
+		content.SetZero()
+	}
+	thunkErr.SetZero()
+	return nil
+}
+
+// setThunkValue rebinds thunk to the value v.
+func setThunkValue(thunk, v reflect.Value) error {
+	node := &ast.Var{Id: evalValue}
+	node.SetFreeVariables(ast.Identifiers{node.Id})
+	if err := setThunk(thunk, node, v); err != nil {
+		return err
+	}
+	// Bind the variable of the body too, in case the content is reset
+	env, err := unexportedField(thunk.Elem(), "env", reflect.Pointer)
+	if err != nil {
+		return err
+	}
+	upValues, err := unexportedField(env.Elem(), "upValues", reflect.Map)
+	if err != nil {
+		return err
+	}
+	ready, err := readyThunk(thunk.Type(), v)
+	if err != nil {
+		return err
+	}
+	upValues.Set(reflect.MakeMap(upValues.Type()))
+	upValues.SetMapIndex(reflect.ValueOf(node.Id), ready)
+	return nil
+}
+
+// evaluateNode evaluates node with the interpreter of dbg, in the
+// environment the debugger is stopped in, and returns the resulting value.
+// node must be desugared and analyzed like the nodes of jsonnet.SnippetToAST.
+// Its free variables are taken from values, or looked up in the environment.
+func evaluateNode(dbg *jsonnet.Debugger, node ast.Node, values map[string]reflect.Value) (reflect.Value, error) {
+	binding, err := selfBinding(dbg)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return evaluateInObject(dbg, node, values, binding)
+}
+
+// evaluateInObject is evaluateNode with self and super taken from binding, a
+// selfBinding, instead of the environment.
+//
+// Debugger.LookupValue is the only way to run the interpreter while
+// stopped, and it evaluates unforced variables in the environment of the
+// innermost frame rather than their own. So node is bound to a thunk with
+// the environment the interpreter would capture for it, which is forced
+// from a frame pushed for the evaluation. Forcing a thunk caches its value,
+// like the program would.
+func evaluateInObject(dbg *jsonnet.Debugger, node ast.Node, values map[string]reflect.Value, binding reflect.Value) (v reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	stack, frames, err := callStack(dbg)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if frames.Len() == 0 {
+		return reflect.Value{}, fmt.Errorf("the evaluation has not started yet")
+	}
+	if usesSelf(node) {
+		self, err := unexportedField(binding, "self", reflect.Pointer)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if self.IsNil() {
+			return reflect.Value{}, fmt.Errorf("Can't use self outside of an object.")
+		}
+	}
+
+	frame := reflect.New(frames.Type().Elem().Elem())
+	frameEnv, err := unexportedField(frame.Elem(), "env", reflect.Struct)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	bindings, err := unexportedField(frameEnv, "upValues", reflect.Map)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	thunkType := bindings.Type().Elem()
+
+	// The environment of the thunk, as captured by callStack.getCurrentEnv
+	env := reflect.New(frameEnv.Type())
+	upValues, err := unexportedField(env.Elem(), "upValues", reflect.Map)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	upValues.Set(reflect.MakeMap(upValues.Type()))
+	for _, id := range node.FreeVariables() {
+		var thunk reflect.Value
+		if v, ok := values[string(id)]; ok {
+			thunk, err = readyThunk(thunkType, v)
+		} else {
+			thunk, err = lookupVariable(dbg, string(id))
+		}
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if !thunk.IsValid() {
+			if id == "$" {
+				return reflect.Value{}, fmt.Errorf("No top-level object found.")
+			}
+			return reflect.Value{}, fmt.Errorf("Unknown variable: %s", id)
+		}
+		upValues.SetMapIndex(reflect.ValueOf(id), thunk)
+	}
+	envBinding, err := unexportedField(env.Elem(), "selfBinding", reflect.Struct)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	envBinding.Set(binding)
+	result := reflect.New(thunkType.Elem())
+	if err := initThunk(result, env, node); err != nil {
+		return reflect.Value{}, err
+	}
+
+	// The result is forced by a tailstrict call, which evaluates to null
+	// rather than to the result as Debugger.LookupValue renders it.
+	force, err := forceResult()
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	forcing := reflect.New(thunkType.Elem())
+	if err := initThunk(forcing, reflect.New(frameEnv.Type()), force); err != nil {
+		return reflect.Value{}, err
+	}
+	bindings.Set(reflect.MakeMap(bindings.Type()))
+	bindings.SetMapIndex(reflect.ValueOf(ast.Identifier(evalValue)), forcing)
+	bindings.SetMapIndex(reflect.ValueOf(ast.Identifier(evalResult)), result)
+
+	// The evaluation pushes frames, and leaves them if it fails
+	saved := reflect.New(stack.Type()).Elem()
+	saved.Set(stack)
+	defer stack.Set(saved)
+	pushed := reflect.MakeSlice(frames.Type(), frames.Len()+1, frames.Len()+1)
+	reflect.Copy(pushed, frames)
+	pushed.Index(frames.Len()).Set(frame)
+	frames.Set(pushed)
+
+	restore, err := keepCurrent(dbg)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	defer restore()
+	if _, err := dbg.LookupValue(evalValue); err != nil {
+		return reflect.Value{}, err
+	}
+	v, err = thunkValue(result)
+	if err == nil && !v.IsValid() {
+		err = fmt.Errorf("unable to evaluate expression")
+	}
+	return v, err
+}
+
+// forceResult returns the node forcing the variable evalResult.
+var forceResult = sync.OnceValues(func() (ast.Node, error) {
+	node, err := jsonnet.SnippetToAST("<debugger>", fmt.Sprintf("local %s = null; (function(_) null)(%s) tailstrict", evalResult, evalResult))
+	if err != nil {
+		return nil, err
+	}
+	local, ok := node.(*ast.Local)
+	if !ok {
+		return nil, fmt.Errorf("unsupported version of go-jsonnet: locals are desugared into %T", node)
+	}
+	return local.Body, nil
+})
+
+// usesSelf reports whether node refers to self or super of its environment.
+func usesSelf(node ast.Node) bool {
+	switch n := node.(type) {
+	case nil:
+		return false
+	case *ast.Self, *ast.SuperIndex, *ast.InSuper:
+		return true
+	case *ast.DesugaredObject:
+		// Only the field names are evaluated in the environment
+		for _, f := range n.Fields {
+			if usesSelf(f.Name) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, c := range toolutils.Children(node) {
+		if usesSelf(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// initThunk makes the new *cachedThunk thunk evaluate body in the
+// *environment env when it is forced.
+func initThunk(thunk, env reflect.Value, body ast.Node) error {
+	e, err := unexportedField(thunk.Elem(), "env", reflect.Pointer)
+	if err != nil {
+		return err
+	}
+	b, err := unexportedField(thunk.Elem(), "body", reflect.Interface)
+	if err != nil {
+		return err
+	}
+	e.Set(env)
+	b.Set(reflect.ValueOf(body))
+	return nil
+}
+
+// readyThunk returns a thunk of type thunkType already evaluated to v.
+func readyThunk(thunkType reflect.Type, v reflect.Value) (reflect.Value, error) {
+	thunk := reflect.New(thunkType.Elem())
+	content, err := unexportedField(thunk.Elem(), "content", reflect.Interface)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	content.Set(v)
+	return thunk, nil
+}
+
+// selfBinding returns the selfBinding of the current environment, mirroring
+// callStack.getSelfBinding.
+func selfBinding(dbg *jsonnet.Debugger) (reflect.Value, error) {
+	_, frames, err := callStack(dbg)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	for k := frames.Len() - 1; k >= 0; k-- {
+		call, err := isCall(frames.Index(k))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if !call {
+			continue
+		}
+		env, err := unexportedField(frames.Index(k).Elem(), "env", reflect.Struct)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return unexportedField(env, "selfBinding", reflect.Struct)
+	}
+	return reflect.Value{}, fmt.Errorf("the evaluation has not started yet")
+}
+
+// lookupVariable returns the *cachedThunk of the variable name of the
+// current environment, invalid if there is no such variable. Functions only
+// capture the variables they use, so `$`, `std` and `$std` are looked up in
+// the enclosing frames as well, and std falls back to the standard library
+// of the interpreter.
+func lookupVariable(dbg *jsonnet.Debugger, name string) (reflect.Value, error) {
+	thunk, err := variableThunk(dbg, name)
+	if err != nil || thunk.IsValid() || name != "$" && name != "std" && name != "$std" {
+		return thunk, err
+	}
+	_, frames, err := callStack(dbg)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	for k := frames.Len() - 1; k >= 0; k-- {
+		upValues, err := frameBindings(frames.Index(k))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if thunk := upValues.MapIndex(reflect.ValueOf(ast.Identifier(name))); thunk.IsValid() {
+			return thunk, nil
+		}
+	}
+	if name == "$" || frames.Len() == 0 {
+		return reflect.Value{}, nil
+	}
+	interp, err := debuggerField(dbg, "interpreter", reflect.Pointer)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	std, err := unexportedField(interp.Elem(), "baseStd", reflect.Pointer)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	upValues, err := frameBindings(frames.Index(0))
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return readyThunk(upValues.Type().Elem(), std)
+}
+
+// thunkValue returns the value of a *cachedThunk, invalid if it has not been
+// evaluated yet.
+func thunkValue(thunk reflect.Value) (reflect.Value, error) {
+	if thunk.Kind() != reflect.Pointer || thunk.IsNil() {
+		return reflect.Value{}, fmt.Errorf("unsupported version of go-jsonnet: %s is not a thunk", thunk.Type())
+	}
+	content, err := unexportedField(thunk.Elem(), "content", reflect.Interface)
+	if err != nil || content.IsNil() {
+		return reflect.Value{}, err
+	}
+	return content.Elem(), nil
+}
+
+// selfObject returns the object self is bound to, mirroring
+// callStack.getSelfBinding. The result is invalid outside of objects.
+func selfObject(dbg *jsonnet.Debugger) (reflect.Value, error) {
+	_, frames, err := callStack(dbg)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	for k := frames.Len() - 1; k >= 0; k-- {
+		call, err := isCall(frames.Index(k))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if !call {
+			continue
+		}
+		env, err := unexportedField(frames.Index(k).Elem(), "env", reflect.Struct)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		binding, err := unexportedField(env, "selfBinding", reflect.Struct)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		self, err := unexportedField(binding, "self", reflect.Pointer)
+		if err != nil || self.IsNil() {
+			return reflect.Value{}, err
+		}
+		return self, nil
+	}
+	return reflect.Value{}, nil
+}
+
+// isValueType reports whether v is a value of the interpreter of the given
+// type, such as valueObject.
+func isValueType(v reflect.Value, name string) bool {
+	return v.IsValid() && v.Kind() == reflect.Pointer && v.Type().Elem().Name() == name &&
+		v.Type().Elem().PkgPath() == reflect.TypeOf((*jsonnet.VM)(nil)).Elem().PkgPath()
+}
+
+// objectField returns the value of the field name of the valueObject obj,
+// invalid if it has not been evaluated yet.
+func objectField(obj reflect.Value, name string) (reflect.Value, error) {
+	cache, key, err := fieldCacheKey(obj, name)
+	if err != nil || !key.IsValid() {
+		return reflect.Value{}, err
+	}
+	v := cache.MapIndex(key)
+	if !v.IsValid() || v.IsNil() {
+		return reflect.Value{}, nil
+	}
+	return v.Elem(), nil
+}
+
+// setObjectField overrides the field name of the valueObject obj with value,
+// as if the field had been evaluated to it.
+func setObjectField(obj reflect.Value, name string, value reflect.Value) error {
+	cache, key, err := fieldCacheKey(obj, name)
+	if err != nil {
+		return err
+	}
+	if !key.IsValid() {
+		return fmt.Errorf("Field does not exist: %s", name)
+	}
+	cache.SetMapIndex(key, value)
+	return nil
+}
+
+// fieldCacheKey returns the cache of the valueObject obj, along with the key
+// the value of the field name is cached under. Like in objectIndex, the key
+// includes the depth of the layer providing the field. The key is invalid if
+// there is no such field.
+func fieldCacheKey(obj reflect.Value, name string) (cache, key reflect.Value, err error) {
+	cache, err = unexportedField(obj.Elem(), "cache", reflect.Map)
+	if err != nil {
+		return reflect.Value{}, reflect.Value{}, err
+	}
+	uncached, err := unexportedField(obj.Elem(), "uncached", reflect.Interface)
+	if err != nil {
+		return reflect.Value{}, reflect.Value{}, err
+	}
+	depth, found, err := fieldDepth(uncached.Elem(), name)
+	if err != nil || !found {
+		return reflect.Value{}, reflect.Value{}, err
+	}
+	key = reflect.New(cache.Type().Key()).Elem()
+	field, err := unexportedField(key, "field", reflect.String)
+	if err != nil {
+		return reflect.Value{}, reflect.Value{}, err
+	}
+	field.SetString(name)
+	d, err := unexportedField(key, "depth", reflect.Int)
+	if err != nil {
+		return reflect.Value{}, reflect.Value{}, err
+	}
+	d.SetInt(int64(depth))
+	return cache, key, nil
+}
+
+// fieldDepth returns the depth of the layer of the uncachedObject obj that
+// provides the field name, mirroring findField.
+func fieldDepth(obj reflect.Value, name string) (int, bool, error) {
+	if obj.Kind() != reflect.Pointer || obj.IsNil() {
+		return 0, false, fmt.Errorf("unsupported version of go-jsonnet: %s is not an object", obj.Type())
+	}
+	if left := obj.Elem().FieldByName("left"); left.IsValid() {
+		l, err := unexportedField(obj.Elem(), "left", reflect.Interface)
+		if err != nil {
+			return 0, false, err
+		}
+		r, err := unexportedField(obj.Elem(), "right", reflect.Interface)
+		if err != nil {
+			return 0, false, err
+		}
+		depth, found, err := fieldDepth(r.Elem(), name)
+		if err != nil || found {
+			return depth, found, err
+		}
+		size, err := inheritanceSize(r.Elem())
+		if err != nil {
+			return 0, false, err
+		}
+		depth, found, err = fieldDepth(l.Elem(), name)
+		return depth + size, found, err
+	}
+	fields, err := unexportedField(obj.Elem(), "fields", reflect.Map)
+	if err != nil {
+		return 0, false, err
+	}
+	return 0, fields.MapIndex(reflect.ValueOf(name)).IsValid(), nil
+}
+
+// inheritanceSize returns the number of layers of the uncachedObject obj,
+// mirroring uncachedObject.inheritanceSize.
+func inheritanceSize(obj reflect.Value) (int, error) {
+	if obj.Kind() != reflect.Pointer || obj.IsNil() {
+		return 0, fmt.Errorf("unsupported version of go-jsonnet: %s is not an object", obj.Type())
+	}
+	if !obj.Elem().FieldByName("totalInheritanceSize").IsValid() {
+		return 1, nil
+	}
+	total, err := unexportedField(obj.Elem(), "totalInheritanceSize", reflect.Int)
+	if err != nil {
+		return 0, err
+	}
+	return int(total.Int()), nil
+}
+
+// arrayElement returns the element i of the valueArray arr, invalid if it
+// is out of bounds or has not been evaluated yet.
+func arrayElement(arr reflect.Value, i int) (reflect.Value, error) {
+	thunk, err := arrayThunk(arr, i)
+	if err != nil || !thunk.IsValid() {
+		return reflect.Value{}, err
+	}
+	return thunkValue(thunk)
+}
+
+// arrayThunk returns the *cachedThunk of the element i of the valueArray
+// arr, invalid if it is out of bounds.
+func arrayThunk(arr reflect.Value, i int) (reflect.Value, error) {
+	elements, err := unexportedField(arr.Elem(), "elements", reflect.Slice)
+	if err != nil || i < 0 || i >= elements.Len() {
+		return reflect.Value{}, err
+	}
+	return elements.Index(i), nil
+}
+
+// objectFields returns the fields of the valueObject obj, including the ones
+// that have not been evaluated yet, with whether they are hidden. It mirrors
+// objectFieldsVisibility.
+func objectFields(obj reflect.Value) (map[string]bool, error) {
+	uncached, err := unexportedField(obj.Elem(), "uncached", reflect.Interface)
+	if err != nil {
+		return nil, err
+	}
+	return layerFields(uncached.Elem(), 0)
+}
+
+// superFields returns the fields super gives access to in the current
+// environment, with whether they are hidden. They are the fields of the
+// layers of self below the one being evaluated, there are none outside of
+// objects.
+func superFields(dbg *jsonnet.Debugger) (map[string]bool, error) {
+	binding, err := selfBinding(dbg)
+	if err != nil {
+		return nil, err
+	}
+	self, err := unexportedField(binding, "self", reflect.Pointer)
+	if err != nil || self.IsNil() {
+		return nil, err
+	}
+	superDepth, err := unexportedField(binding, "superDepth", reflect.Int)
+	if err != nil {
+		return nil, err
+	}
+	uncached, err := unexportedField(self.Elem(), "uncached", reflect.Interface)
+	if err != nil {
+		return nil, err
+	}
+	return layerFields(uncached.Elem(), int(superDepth.Int())+1)
+}
+
+// layerFields returns the fields of the layers of the uncachedObject obj
+// from minDepth on, with whether they are hidden.
+func layerFields(obj reflect.Value, minDepth int) (map[string]bool, error) {
+	visibility := map[string]ast.ObjectFieldHide{}
+	if err := fieldsVisibility(obj, minDepth, visibility); err != nil {
+		return nil, err
+	}
+	hidden := map[string]bool{}
+	for name, hide := range visibility {
+		hidden[name] = hide == ast.ObjectFieldHidden
+	}
+	return hidden, nil
+}
+
+// fieldsVisibility adds the fields of the layers of an uncachedObject from
+// minDepth on to visibility, with the fields of the right hand side of an
+// inheritance overriding the left hand side unless they inherit its
+// visibility. Layers are counted from the right like in findField.
+func fieldsVisibility(obj reflect.Value, minDepth int, visibility map[string]ast.ObjectFieldHide) error {
+	if obj.Kind() != reflect.Pointer || obj.IsNil() {
+		return fmt.Errorf("unsupported version of go-jsonnet: %s is not an object", obj.Type())
+	}
+	if left := obj.Elem().FieldByName("left"); left.IsValid() {
+		l, err := unexportedField(obj.Elem(), "left", reflect.Interface)
+		if err != nil {
+			return err
+		}
+		r, err := unexportedField(obj.Elem(), "right", reflect.Interface)
+		if err != nil {
+			return err
+		}
+		size, err := inheritanceSize(r.Elem())
+		if err != nil {
+			return err
+		}
+		if err := fieldsVisibility(l.Elem(), minDepth-size, visibility); err != nil {
+			return err
+		}
+		right := map[string]ast.ObjectFieldHide{}
+		if size > minDepth {
+			if err := fieldsVisibility(r.Elem(), minDepth, right); err != nil {
+				return err
+			}
+		}
+		for name, hide := range right {
+			if _, ok := visibility[name]; !ok || hide != ast.ObjectFieldInherit {
+				visibility[name] = hide
+			}
+		}
+		return nil
+	}
+	if minDepth > 0 {
+		return nil
+	}
+	fields, err := unexportedField(obj.Elem(), "fields", reflect.Map)
+	if err != nil {
+		return err
+	}
+	iter := fields.MapRange()
+	for iter.Next() {
+		hide := iter.Value().FieldByName("hide")
+		if hide.Kind() != reflect.Int {
+			return fmt.Errorf("unsupported version of go-jsonnet: simpleObjectField.hide is not an int")
+		}
+		visibility[iter.Key().String()] = ast.ObjectFieldHide(hide.Int())
+	}
+	return nil
+}
+
+// inspectValue converts the value v of the interpreter into a debugValue.
+// Fields and elements are only included if they have been evaluated, down to
+// depth levels of nesting, or all of them if depth is negative.
+func inspectValue(v reflect.Value, depth int) (*debugValue, error) {
+	return inspect(v, depth, map[uintptr]bool{})
+}
+
+// inspect implements inspectValue, visiting are the arrays and objects v is
+// nested in, which values may reference again.
+func inspect(v reflect.Value, depth int, visiting map[uintptr]bool) (*debugValue, error) {
+	if !v.IsValid() || v.Kind() != reflect.Pointer || v.IsNil() {
+		return &debugValue{}, nil
+	}
+	out := &debugValue{runtime: v}
+	switch {
+	case isValueType(v, "valueNull"):
+		out.kind = valueKindNull
+	case isValueType(v, "valueBoolean"):
+		b, err := unexportedField(v.Elem(), "value", reflect.Bool)
+		if err != nil {
+			return nil, err
+		}
+		out.kind, out.scalar = valueKindBoolean, strconv.FormatBool(b.Bool())
+	case isValueType(v, "valueNumber"):
+		f, err := unexportedField(v.Elem(), "value", reflect.Float64)
+		if err != nil {
+			return nil, err
+		}
+		out.kind, out.scalar = valueKindNumber, formatNumber(f.Float())
+	case isValueType(v, "valueFlatString") || isValueType(v, "valueStringTree"):
+		var sb strings.Builder
+		if err := stringValue(v, &sb); err != nil {
+			return nil, err
+		}
+		out.kind, out.scalar = valueKindString, sb.String()
+	case isValueType(v, "valueFunction"):
+		params, err := functionParameters(v)
+		if err != nil {
+			return nil, err
+		}
+		out.kind, out.scalar = valueKindFunction, strings.Join(params, ", ")
+	case isValueType(v, "valueArray"):
+		out.kind = valueKindArray
+		elements, err := unexportedField(v.Elem(), "elements", reflect.Slice)
+		if err != nil {
+			return nil, err
+		}
+		out.size = elements.Len()
+		if depth == 0 || visiting[v.Pointer()] {
+			out.truncated = true
+			return out, nil
+		}
+		visiting[v.Pointer()] = true
+		defer delete(visiting, v.Pointer())
+		for i := 0; i < elements.Len(); i++ {
+			e, err := thunkValue(elements.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			element, err := inspect(e, depth-1, visiting)
+			if err != nil {
+				return nil, err
+			}
+			out.elements = append(out.elements, element)
+		}
+	case isValueType(v, "valueObject"):
+		out.kind = valueKindObject
+		hidden, err := objectFields(v)
+		if err != nil {
+			return nil, err
+		}
+		out.size = len(hidden)
+		if depth == 0 || visiting[v.Pointer()] {
+			out.truncated = true
+			return out, nil
+		}
+		visiting[v.Pointer()] = true
+		defer delete(visiting, v.Pointer())
+		for name, hide := range hidden {
+			f, err := objectField(v, name)
+			if err != nil {
+				return nil, err
+			}
+			field, err := inspect(f, depth-1, visiting)
+			if err != nil {
+				return nil, err
+			}
+			out.fields = append(out.fields, debugField{name: name, hidden: hide, value: field})
+		}
+		sort.Slice(out.fields, func(i, j int) bool { return out.fields[i].name < out.fields[j].name })
+	default:
+		out.scalar = v.Type().Elem().Name()
+	}
+	return out, nil
+}
+
+// stringValue writes the contents of the valueString v to sb, flattening
+// the trees built by concatenation.
+func stringValue(v reflect.Value, sb *strings.Builder) error {
+	if isValueType(v, "valueFlatString") {
+		runes, err := unexportedField(v.Elem(), "value", reflect.Slice)
+		if err != nil {
+			return err
+		}
+		r, ok := runes.Interface().([]rune)
+		if !ok {
+			return fmt.Errorf("unsupported version of go-jsonnet: valueFlatString.value is not a []rune")
+		}
+		sb.WriteString(string(r))
+		return nil
+	}
+	for _, side := range []string{"left", "right"} {
+		s, err := unexportedField(v.Elem(), side, reflect.Interface)
+		if err != nil {
+			return err
+		}
+		if err := stringValue(s.Elem(), sb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// functionParameters returns the parameter names of the valueFunction fn,
+// which may be a closure, a builtin or a native function.
+func functionParameters(fn reflect.Value) ([]string, error) {
+	ec, err := unexportedField(fn.Elem(), "ec", reflect.Interface)
+	if err != nil {
+		return nil, err
+	}
+	if ec.IsNil() || ec.Elem().Kind() != reflect.Pointer {
+		return nil, fmt.Errorf("unsupported version of go-jsonnet: valueFunction.ec is not a pointer")
+	}
+	callable := ec.Elem().Elem()
+	params := callable.FieldByName("params")
+	if !params.IsValid() {
+		params = callable.FieldByName("Params")
+	}
+	if params.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("unsupported version of go-jsonnet: %s has no parameters", callable.Type())
+	}
+	names := make([]string, 0, params.Len())
+	for i := 0; i < params.Len(); i++ {
+		p := params.Index(i)
+		if p.Kind() == reflect.Struct {
+			p = p.FieldByName("name")
+		}
+		if p.Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported version of go-jsonnet: %s has no parameter names", callable.Type())
+		}
+		names = append(names, p.String())
+	}
+	return names, nil
+}
+
+// stdFunctions returns the parameter names of the functions of the standard
+// library, from the std object of the interpreter.
+func stdFunctions(dbg *jsonnet.Debugger) (map[string][]string, error) {
+	interp, err := debuggerField(dbg, "interpreter", reflect.Pointer)
+	if err != nil {
+		return nil, err
+	}
+	if interp.IsNil() {
+		return nil, fmt.Errorf("the evaluation has not started")
+	}
+	std, err := unexportedField(interp.Elem(), "baseStd", reflect.Pointer)
+	if err != nil {
+		return nil, err
+	}
+	uncached, err := unexportedField(std.Elem(), "uncached", reflect.Interface)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := unexportedField(uncached.Elem().Elem(), "fields", reflect.Map)
+	if err != nil {
+		return nil, err
+	}
+	functions := map[string][]string{}
+	iter := fields.MapRange()
+	for iter.Next() {
+		// Copy the field so its unexported contents can be reached
+		f := reflect.New(iter.Value().Type()).Elem()
+		f.Set(iter.Value())
+		unbound, err := unexportedField(f, "field", reflect.Interface)
+		if err != nil {
+			return nil, err
+		}
+		if unbound.IsNil() || unbound.Elem().Kind() != reflect.Pointer {
+			continue
+		}
+		field := unbound.Elem().Elem()
+		switch {
+		case field.FieldByName("body").IsValid():
+			// Functions defined in std.jsonnet
+			body, err := unexportedField(field, "body", reflect.Interface)
+			if err != nil {
+				return nil, err
+			}
+			fn, ok := body.Interface().(*ast.Function)
+			if !ok {
+				continue
+			}
+			params := []string{}
+			for _, p := range fn.Parameters {
+				params = append(params, string(p.Name))
+			}
+			functions[iter.Key().String()] = params
+		case field.FieldByName("content").IsValid():
+			// Builtins
+			content, err := unexportedField(field, "content", reflect.Interface)
+			if err != nil {
+				return nil, err
+			}
+			if !isValueType(content.Elem(), "valueFunction") {
+				continue
+			}
+			params, err := functionParameters(content.Elem())
+			if err != nil {
+				return nil, err
+			}
+			functions[iter.Key().String()] = params
+		}
+	}
+	return functions, nil
+}
+
+// launch starts the evaluation like Debugger.Launch, but with importer,
+// which Debugger.Launch replaces with a FileImporter.
+func launch(dbg *jsonnet.Debugger, filename, snippet string, importer jsonnet.Importer) error {
+	vm, err := debuggerVM(dbg)
+	if err != nil {
+		return err
+	}
+	vm.Importer(importer)
+	go func() {
+		out, err := vm.EvaluateAnonymousSnippet(filename, snippet)
+		dbg.Events() <- &jsonnet.DebugEventExit{
+			Output: out,
+			Error:  err,
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+)
+
+// programFile is a Jsonnet file that is part of the program being debugged.
+type programFile struct {
+	// path is the name the importer found the file under. Locations in the
+	// debugger refer to files by this name.
+	path string
+	// node is the desugared AST, as evaluated by the debugger.
+	node ast.Node
+	// imports maps the paths imported by the file to the path of the file
+	// the importer found.
+	imports map[string]string
+}
+
+// resolveProgram parses the main file and every file it imports, directly
+// or indirectly, resolving imports the same way Debugger.Launch does. Files
+// that fail to import or parse are skipped, the evaluation will report them.
+func resolveProgram(filename, snippet string, jpaths []string) ([]*programFile, error) {
+	node, err := jsonnet.SnippetToAST(filename, snippet)
+	if err != nil {
+		return nil, err
+	}
+	importer := &jsonnet.FileImporter{
+		JPaths: append(append([]string{}, jpaths...), filepath.Dir(filename)),
+	}
+	files := []*programFile{{path: filename, node: node, imports: map[string]string{}}}
+	seen := map[string]bool{filename: true}
+	for i := 0; i < len(files); i++ {
+		from := files[i].path
+		walk(files[i].node, func(n ast.Node) {
+			imp, ok := n.(*ast.Import)
+			if !ok {
+				return
+			}
+			contents, foundAt, err := importer.Import(from, imp.File.Value)
+			if err != nil {
+				slog.Debug("unable to resolve import", "from", from, "path", imp.File.Value, "err", err)
+				return
+			}
+			files[i].imports[imp.File.Value] = foundAt
+			if seen[foundAt] {
+				return
+			}
+			seen[foundAt] = true
+			node, err := jsonnet.SnippetToAST(foundAt, contents.String())
+			if err != nil {
+				slog.Debug("unable to parse import", "file", foundAt, "err", err)
+				return
+			}
+			files = append(files, &programFile{path: foundAt, node: node, imports: map[string]string{}})
+		})
+	}
+	return files, nil
+}
+
+// walk calls f for node and all its descendants.
+func walk(node ast.Node, f func(ast.Node)) {
+	if node == nil {
+		return
+	}
+	f(node)
+	for _, c := range toolutils.Children(node) {
+		walk(c, f)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -15,7 +16,19 @@ import (
 )
 
 type ReplDebugger struct {
-	dbg      *jsonnet.Debugger
+	dbg         *jsonnet.Debugger
+	breakpoints *breakpointTable
+	stepper     stepper
+	restarter   frameRestarter
+	frames      frameSelector
+	// hooks follow the evaluation, to pause it, step out and restart
+	// frames.
+	hooks evalHooks
+	// sources are the files loaded by the program.
+	sources loadedSources
+	// frame is the stack frame inspected by p, vars and l, counted from the
+	// innermost one.
+	frame    int
 	line     *liner.State
 	histFile string
 	raw      string
@@ -23,7 +36,10 @@ type ReplDebugger struct {
 	jpaths   []string
 }
 
-func MakeReplDebugger(filename, snippet string, jpaths []string) *ReplDebugger {
+// MakeReplDebugger creates the debugger of the command line interface.
+// restartFrames enables the reeval command, which replays a stack frame,
+// see frameRestarter.
+func MakeReplDebugger(filename, snippet string, jpaths []string, restartFrames bool) *ReplDebugger {
 	line := liner.NewLiner()
 	line.SetCtrlCAborts(true)
 	histFile := filepath.Join(os.TempDir(), ".jsonnice-history")
@@ -32,23 +48,47 @@ func MakeReplDebugger(filename, snippet string, jpaths []string) *ReplDebugger {
 		f.Close()
 	}
 	dbg := jsonnet.MakeDebugger()
-	return &ReplDebugger{
-		line:     line,
-		dbg:      dbg,
-		histFile: histFile,
-		raw:      snippet,
-		filename: filename,
-		jpaths:   jpaths,
+	r := &ReplDebugger{
+		line:        line,
+		dbg:         dbg,
+		breakpoints: newBreakpointTable(),
+		histFile:    histFile,
+		raw:         snippet,
+		filename:    filename,
+		jpaths:      jpaths,
 	}
+	if err := r.hooks.install(dbg); err != nil {
+		slog.Warn("the evaluation cannot be paused", "err", err)
+	}
+	if restartFrames {
+		if err := r.restarter.install(&r.hooks); err != nil {
+			slog.Warn("frames cannot be restarted", "err", err)
+		}
+	}
+	return r
 }
 
 func (r *ReplDebugger) Run() {
 	defer r.line.Close()
 	events := r.dbg.Events()
+	// Ctrl-C pauses the evaluation. While prompting, liner handles it
+	// itself as the terminal is in raw mode.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
 	r.repl(nil, nil, nil)
 EVENTLOOP:
 	for {
-		msg := <-events
+		var msg jsonnet.DebugEvent
+		select {
+		case msg = <-events:
+		case <-interrupts:
+			if err := r.hooks.pause(); err != nil {
+				fmt.Printf("Unable to pause: %s\n", err)
+				os.Exit(1)
+			}
+			continue
+		}
 		slog.Info("received event", "type", fmt.Sprintf("%T", msg))
 		switch e := msg.(type) {
 		case *jsonnet.DebugEventExit:
@@ -62,15 +102,56 @@ EVENTLOOP:
 		case *jsonnet.DebugEventStop:
 			switch e.Reason {
 			case jsonnet.StopReasonBreakpoint:
+				if stop, _ := r.checkBreakpoints(e); !stop {
+					r.dbg.Continue()
+					continue
+				}
+				r.stepper.reset()
+				r.hooks.cancelPause()
 				color.Bold.Print("Hit breakpoint: ")
 				color.OpUnderscore.Println(e.Breakpoint)
 				r.printCurrentContext(e.Current)
 			case jsonnet.StopReasonStep:
+				if r.restarter.restarted() {
+					color.Bold.Println("Replaying the frame")
+					fmt.Println(replayNote)
+					r.printStackTrace()
+					r.printCurrentContext(e.Current)
+					break
+				}
+				if r.hooks.paused() {
+					r.stepper.reset()
+					color.Bold.Println("Paused")
+					r.printStackTrace()
+					r.printCurrentContext(e.Current)
+					break
+				}
+				if r.stepper.stepping() {
+					// The debugger reports breakpoints as steps while stepping
+					if stop, atBreakpoint := r.checkBreakpoints(e); stop && atBreakpoint {
+						r.stepper.reset()
+						color.Bold.Print("Hit breakpoint: ")
+						color.OpUnderscore.Println(e.Current.Loc().Begin.String())
+						r.printCurrentContext(e.Current)
+						break
+					}
+					if !r.stepper.done(e) {
+						r.dbg.Step()
+						continue
+					}
+					if returned, ok := r.stepper.returnValue(); ok {
+						fmt.Printf("Returned: %s\n", color.Magenta.Render(valuePreview(returned)))
+					}
+				}
 				r.printCurrentContext(e.Current)
 			case jsonnet.StopReasonException:
+				r.stepper.reset()
+				r.hooks.cancelPause()
 				fmt.Printf("%s: %s\n", color.Red.Render("Encountered error during evaluation"), e.ErrorFmt())
 				r.printCurrentContext(e.Current)
 			}
+			r.breakpoints.removeTemporary(r.dbg)
+			r.frame = 0
 			r.repl(e.Current, e.LastEvaluation, e.Error)
 		}
 	}
@@ -80,11 +161,33 @@ EVENTLOOP:
 	}
 }
 
+// checkBreakpoints evaluates the breakpoints at the node the debugger
+// stopped at, and prints condition errors and logpoint messages. It returns
+// whether to stop, and whether there are any breakpoints at the node.
+func (r *ReplDebugger) checkBreakpoints(e *jsonnet.DebugEventStop) (bool, bool) {
+	stop, hits := r.breakpoints.check(r.dbg, e.Current)
+	for _, h := range hits {
+		if h.err != nil {
+			fmt.Printf("%s: %s\n", color.Red.Render("Failed to evaluate breakpoint condition"), h.err)
+		}
+		if h.logMsg != "" {
+			fmt.Printf("%s %s\n", color.Gray.Render(h.bp.location+":"), h.logMsg)
+		}
+	}
+	return stop, len(hits) > 0
+}
+
 func (d *ReplDebugger) printCurrentContext(current ast.Node) {
-	lines := strings.Split(d.raw, "\n")
-	lines = append([]string{""}, current.Loc().File.Lines...)
+	d.printContext(current.Loc())
+}
+
+func (d *ReplDebugger) printContext(loc *ast.LocationRange) {
+	if loc.File == nil {
+		fmt.Println("No source available")
+		return
+	}
+	lines := append([]string{""}, loc.File.Lines...)
 	clines := 3 // how many lines of context to show
-	loc := current.Loc()
 	for i := loc.Begin.Line - clines; i < loc.Begin.Line; i++ {
 		if i < 1 {
 			continue
@@ -124,25 +227,7 @@ func (r *ReplDebugger) repl(current ast.Node, lastVal *string, jerr error) {
 	if jerr != nil {
 		fmt.Print(color.Red.Render("! "))
 	}
-	r.line.SetCompleter(func(line string) (c []string) {
-		parts := strings.Split(line, " ")
-		switch parts[0] {
-		case "b", "break":
-			if len(parts) < 2 {
-				return
-			}
-			loc, err := r.dbg.BreakpointLocations(r.filename)
-			if err != nil {
-				slog.Warn("Unable to autocomplete breakpoints", "err", err)
-			}
-			for _, l := range loc {
-				if strings.HasPrefix(l.String(), parts[1]) {
-					c = append(c, fmt.Sprintf("%s %s:%s", parts[0], l.File.DiagnosticFileName, l.Begin.String()))
-				}
-			}
-		}
-		return
-	})
+	r.line.SetCompleter(r.complete)
 	input, err := r.line.Prompt(p)
 	if err == liner.ErrPromptAborted {
 		os.Exit(1)
@@ -153,49 +238,96 @@ func (r *ReplDebugger) repl(current ast.Node, lastVal *string, jerr error) {
 	switch parts[0] {
 	case "b", "break":
 		if len(parts) < 2 {
-			for _, b := range r.dbg.ActiveBreakpoints() {
+			for _, b := range r.breakpoints.list() {
 				fmt.Printf("- %s\n", b)
 			}
 			break
 		}
-		binfo := strings.Split(parts[1], ":")
-		if len(binfo) < 2 {
-			fmt.Println("Must specify file and line separated by `:`")
+		if parts[1] == "fn" {
+			if len(parts) < 3 {
+				fmt.Println("Usage: break fn <name>")
+				break
+			}
+			r.addFunctionBreakpoint(parts[2])
 			break
 		}
-		line, err := strconv.Atoi(binfo[1])
+		file, line, column, err := parseLocation(parts[1])
 		if err != nil {
-			fmt.Printf("Invalid line number: %s\n", err.Error())
+			fmt.Println(err)
 			break
 		}
-		column := -1
-		if len(binfo) == 3 {
-			cint, err := strconv.Atoi(binfo[2])
+		var hitCond *hitCondition
+		if len(parts) > 2 {
+			hitCond, err = parseHitCondition(strings.Join(parts[2:], " "))
 			if err != nil {
-				fmt.Printf("Invalid column number: %s\n", err.Error())
+				fmt.Println(err)
 				break
 			}
-			column = cint
 		}
-		if target, err := r.dbg.SetBreakpoint(binfo[0], line, column); err != nil {
+		r.addBreakpoint(&breakpoint{file: file, line: line, hitCondition: hitCond}, column)
+	case "logpoint":
+		if len(parts) < 3 {
+			fmt.Println("Usage: logpoint file:line \"message {expression}\"")
+			break
+		}
+		file, line, column, err := parseLocation(parts[1])
+		if err != nil {
 			fmt.Println(err)
-		} else {
-			fmt.Printf("Adding breakpoint at %s\n", target)
+			break
 		}
+		msg := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(input), parts[0]+" "+parts[1]))
+		if unquoted, err := strconv.Unquote(msg); err == nil {
+			msg = unquoted
+		}
+		r.addBreakpoint(&breakpoint{file: file, line: line, logMessage: msg}, column)
 	case "n", "next":
+		r.stepper.reset()
 		r.dbg.ContinueUntilAfter(current)
 		return
 	case "s":
+		r.stepper.reset()
 		r.dbg.Step()
 		return
+	case "reeval":
+		if current == nil {
+			fmt.Println("The evaluation has not started yet")
+			break
+		}
+		r.stepper.reset()
+		if err := r.restarter.restart(r.dbg, len(stackTrace(r.dbg))-1-r.frame); err != nil {
+			fmt.Println(err)
+			break
+		}
+		return
+	case "o", "finish":
+		if current == nil {
+			fmt.Println("The evaluation has not started yet")
+			break
+		}
+		if err := r.stepper.stepOut(r.dbg, &r.hooks); err != nil {
+			fmt.Println(err)
+			break
+		}
+		return
 	case "l":
-		if current != nil {
-			r.printCurrentContext(current)
-		} else {
+		switch {
+		case current == nil:
 			r.printFile()
+		case r.frame == 0:
+			r.printCurrentContext(current)
+		default:
+			r.inFrame(func(trace []jsonnet.TraceFrame) {
+				r.printContext(&trace[len(trace)-1-r.frame].Loc)
+			})
+		}
+	case "up", "down", "frame":
+		if current == nil {
+			fmt.Println("The evaluation has not started yet")
+			break
 		}
+		r.selectFrame(parts)
 	case "lb", "lbs": // list possible breakpoints
-		loc, err := r.dbg.BreakpointLocations(r.filename)
+		loc, err := stopLocations(r.filename)
 		if err != nil {
 			slog.Warn("Unable to autocomplete breakpoints", "err", err)
 		}
@@ -203,46 +335,75 @@ func (r *ReplDebugger) repl(current ast.Node, lastVal *string, jerr error) {
 			fmt.Printf("- %s:%s\n", l.File.DiagnosticFileName, l.Begin.String())
 		}
 	case "p":
-		if len(parts) < 2 {
-			parts = append(parts, "self")
+		expr := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(input), parts[0]))
+		if expr == "" {
+			expr = "self"
 		}
-		val, err := r.dbg.LookupValue(parts[1])
-		if err != nil {
-			fmt.Println(err.Error())
-		} else {
-			fmt.Println(val)
-		}
-	case "trace":
-		tr := r.dbg.StackTrace()
-		for _, frame := range tr {
-			fmt.Printf("- %s", frame.Name)
-			if frame.Loc.File != nil {
-				fmt.Print("\t\t\t")
-				fmt.Print(color.Gray.Render(fmt.Sprintf("%s:%d:%d", frame.Loc.File.DiagnosticFileName, frame.Loc.Begin.Line, frame.Loc.Begin.Column)))
+		r.inFrame(func([]jsonnet.TraceFrame) {
+			val, err := printValue(r.dbg, expr)
+			if err != nil {
+				fmt.Println(color.Red.Render(err.Error()))
+			} else {
+				fmt.Println(val)
 			}
-			fmt.Print("\n")
+		})
+	case "set":
+		target, expr, ok := strings.Cut(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(input), parts[0])), "=")
+		target, expr = strings.TrimSpace(target), strings.TrimSpace(expr)
+		if !ok || target == "" || expr == "" {
+			fmt.Println("Usage: set <variable> = <expression>")
+			break
 		}
+		r.inFrame(func([]jsonnet.TraceFrame) {
+			if err := assign(r.dbg, target, expr); err != nil {
+				fmt.Println(color.Red.Render(err.Error()))
+				return
+			}
+			val, err := evaluate(r.dbg, target)
+			if err != nil {
+				fmt.Println(color.Red.Render(err.Error()))
+			} else {
+				fmt.Printf("%s = %s\n", target, val)
+			}
+		})
+	case "trace":
+		r.printStackTrace()
 	case "last":
 		if lastVal != nil {
 			fmt.Printf("Last evaluation: %s\n", color.Magenta.Render(*lastVal))
 		}
 	case "vars":
-		vars := r.dbg.ListVars()
-		fmt.Printf("Variables:\n")
-		for _, v := range vars {
-			fmt.Printf("- %s\n", v)
-		}
+		r.inFrame(func([]jsonnet.TraceFrame) {
+			fmt.Printf("Variables:\n")
+			for _, v := range r.dbg.ListVars() {
+				fmt.Printf("- %s\n", v)
+			}
+		})
 	case "q":
 		r.dbg.Terminate()
 		return
 	case "clear":
-		r.dbg.ClearBreakpoints(parts[1])
+		r.breakpoints.clear(r.dbg, parts[1])
 	case "c":
-		if current == nil {
-			r.dbg.Launch(r.filename, r.raw, r.jpaths)
-		} else {
-			r.dbg.Continue()
+		r.resume(current)
+		return
+	case "until", "advance":
+		if len(parts) < 2 {
+			fmt.Println("Usage: until file:line[:column]")
+			break
 		}
+		file, line, column, err := parseLocation(parts[1])
+		if err != nil {
+			fmt.Println(err)
+			break
+		}
+		bp := &breakpoint{file: file, line: line, column: column, temporary: true}
+		if err := bp.place(r.dbg, &r.sources); err != nil {
+			fmt.Println(err)
+			break
+		}
+		r.breakpoints.add(bp)
+		r.resume(current)
 		return
 	case "":
 	default:
@@ -251,6 +412,195 @@ func (r *ReplDebugger) repl(current ast.Node, lastVal *string, jerr error) {
 	r.repl(current, nil, jerr)
 }
 
+// resume continues the evaluation, or starts it if it has not started yet.
+func (r *ReplDebugger) resume(current ast.Node) {
+	if current == nil {
+		// Like Debugger.Launch, the directory of the program takes
+		// precedence over the library paths
+		jpaths := append(append([]string{}, r.jpaths...), filepath.Dir(r.filename))
+		launchTracked(r.dbg, r.filename, r.raw, jpaths, r.sourceLoaded)
+		return
+	}
+	r.stepper.reset()
+	r.dbg.Continue()
+}
+
+// addBreakpoint sets bp in the debugger and registers it.
+func (r *ReplDebugger) addBreakpoint(bp *breakpoint, column int) {
+	bp.column = column
+	if err := bp.place(r.dbg, &r.sources); err != nil {
+		fmt.Println(err)
+		return
+	}
+	bp.id = r.breakpoints.newID()
+	r.breakpoints.add(bp)
+	if bp.pending {
+		fmt.Printf("Breakpoint %d at %s:%d pending until the file is loaded\n", bp.id, bp.file, bp.line)
+		return
+	}
+	if bp.logMessage != "" {
+		fmt.Printf("Adding logpoint at %s\n", bp.location)
+	} else {
+		fmt.Printf("Adding breakpoint at %s\n", bp.location)
+	}
+}
+
+// sourceLoaded sets the pending breakpoints of files loaded for the first
+// time.
+func (r *ReplDebugger) sourceLoaded(s loadedSource) {
+	if !r.sources.add(s) {
+		return
+	}
+	for _, bp := range r.breakpoints.resolve(r.dbg, s.path) {
+		if bp.at == nil {
+			fmt.Printf("Unable to set breakpoint %d: %s\n", bp.id, bp.message)
+		} else {
+			fmt.Printf("Breakpoint %d set at %s\n", bp.id, bp.location)
+		}
+	}
+}
+
+// addFunctionBreakpoint breaks on every definition of the named function.
+func (r *ReplDebugger) addFunctionBreakpoint(name string) {
+	program, err := resolveProgram(r.filename, r.raw, r.jpaths)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defs := findFunctions(program, name)
+	if len(defs) == 0 {
+		fmt.Printf("No function named %s found\n", name)
+		return
+	}
+	if defs[0].call {
+		fmt.Println(stdCallNote)
+	}
+	for _, def := range defs {
+		bp := &breakpoint{file: def.file, line: def.at.Begin.Line, function: def.name}
+		if !def.literal {
+			r.addBreakpoint(bp, def.at.Begin.Column)
+			continue
+		}
+		// The debugger never stops at literals, the evaluation hooks do
+		bp.setLiteral(def.at)
+		bp.column = def.at.Begin.Column
+		bp.id = r.breakpoints.newID()
+		r.breakpoints.add(bp)
+		if err := r.hooks.breakAtLiterals(r.breakpoints.literals()); err != nil {
+			r.breakpoints.drop(func(b *breakpoint) bool { return b == bp })
+			fmt.Printf("Unable to break on %s at %s:%s: %s\n", def.name, def.file, def.at.Begin.String(), err)
+			continue
+		}
+		fmt.Printf("Adding breakpoint at %s\n", bp.location)
+	}
+}
+
+// selectFrame handles the up, down and frame commands.
+func (r *ReplDebugger) selectFrame(parts []string) {
+	frame := r.frame
+	n := 1
+	if len(parts) > 1 {
+		var err error
+		if n, err = strconv.Atoi(parts[1]); err != nil || n < 0 {
+			fmt.Printf("Invalid frame number: %s\n", parts[1])
+			return
+		}
+	}
+	switch parts[0] {
+	case "up":
+		frame += n
+	case "down":
+		frame -= n
+	case "frame":
+		if len(parts) > 1 {
+			frame = n
+		}
+	}
+	frames := len(stackTrace(r.dbg))
+	if frame < 0 || frame >= frames {
+		fmt.Printf("No frame %d, frames are numbered from 0 (innermost) to %d\n", frame, frames-1)
+		return
+	}
+	r.frame = frame
+	r.inFrame(func(trace []jsonnet.TraceFrame) {
+		r.printFrame(trace, len(trace)-1-r.frame)
+		r.printContext(&trace[len(trace)-1-r.frame].Loc)
+	})
+}
+
+// inFrame runs f in the environment of the selected stack frame.
+func (r *ReplDebugger) inFrame(f func(trace []jsonnet.TraceFrame)) {
+	index := len(stackTrace(r.dbg)) - 1 - r.frame
+	if err := r.frames.in(r.dbg, index, f); err != nil {
+		fmt.Println(color.Red.Render(err.Error()))
+	}
+}
+
+// replCommands are the commands completed at the start of the line.
+var replCommands = []string{
+	"advance", "b", "break", "c", "clear", "down", "finish", "frame", "l",
+	"last", "lb", "logpoint", "n", "next", "o", "p", "q", "reeval", "s", "set",
+	"trace", "until", "up", "vars",
+}
+
+// complete completes the commands, the locations of breakpoints, and the
+// expressions printed with p.
+func (r *ReplDebugger) complete(line string) (c []string) {
+	parts := strings.Split(line, " ")
+	if len(parts) == 1 {
+		for _, cmd := range replCommands {
+			if strings.HasPrefix(cmd, line) {
+				c = append(c, cmd+" ")
+			}
+		}
+		return
+	}
+	switch parts[0] {
+	case "b", "break", "until", "advance":
+		loc, err := stopLocations(r.filename)
+		if err != nil {
+			slog.Warn("Unable to autocomplete breakpoints", "err", err)
+		}
+		for _, l := range loc {
+			if strings.HasPrefix(l.String(), parts[1]) {
+				c = append(c, fmt.Sprintf("%s %s:%s", parts[0], l.File.DiagnosticFileName, l.Begin.String()))
+			}
+		}
+	case "p":
+		index := len(stackTrace(r.dbg)) - 1 - r.frame
+		r.frames.in(r.dbg, index, func([]jsonnet.TraceFrame) {
+			prefix, candidates := complete(r.dbg, line)
+			for _, candidate := range candidates {
+				c = append(c, line[:len(line)-len(prefix)]+candidate.label)
+			}
+		})
+	}
+	return
+}
+
+func (r *ReplDebugger) printStackTrace() {
+	trace := stackTrace(r.dbg)
+	for i := range trace {
+		r.printFrame(trace, i)
+	}
+}
+
+// printFrame prints trace[i], numbered like the frames selected with up,
+// down and frame.
+func (r *ReplDebugger) printFrame(trace []jsonnet.TraceFrame, i int) {
+	frame := trace[i]
+	marker := " "
+	if len(trace)-1-i == r.frame {
+		marker = "*"
+	}
+	fmt.Printf("%s#%d %s", marker, len(trace)-1-i, frame.Name)
+	if frame.Loc.File != nil {
+		fmt.Print("\t\t\t")
+		fmt.Print(color.Gray.Render(fmt.Sprintf("%s:%d:%d", frame.Loc.File.DiagnosticFileName, frame.Loc.Begin.Line, frame.Loc.Begin.Column)))
+	}
+	fmt.Print("\n")
+}
+
 func (r *ReplDebugger) printFile() {
 	fmt.Printf("File: %s\n", color.FgBlue.Render(r.filename))
 	lines := strings.Split(r.raw, "\n")
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// frameRestarter replays stack frames, evaluating them again from their
+// start. This is not a rewind: the interpreter cannot go back, so the frame
+// is evaluated a second time from where the debugger is stopped, in the
+// environment of the frame and with the hooks of the debugger enabled so it
+// can be stepped through. Values the frame already forced stay cached and
+// are not computed again. Jsonnet has no side effects, which makes this
+// safe. Once that evaluation completes, the interrupted one resumes where it
+// was stopped, and the result of the frame is still the one it computes.
+//
+// The debugger does not record where frames start, so the first node
+// evaluated in every call is tracked from the evaluation hooks. As this
+// slows the evaluation down, it is only enabled on request.
+type frameRestarter struct {
+	mu sync.Mutex
+	// starts are the calls of the interpreter stack, outermost first, with
+	// the node they started with.
+	starts []frameStart
+	// pending is the frame to evaluate again once the evaluation resumes.
+	pending *frameStart
+	// restarting is set until the debugger stops in the restarted frame.
+	restarting bool
+	installed  bool
+
+	// stopped is set while the interpreter waits in a hook of the debugger.
+	// Hooks called meanwhile come from the frontend inspecting values.
+	stopped atomic.Bool
+	// depth counts the nested evaluations of restarted frames, it is only
+	// accessed by the interpreter.
+	depth int
+}
+
+// replayNote tells users what restarting a frame does.
+const replayNote = "The frame is evaluated again from its start, the evaluation is not rewound: values it computed already are reused, and it resumes where it was stopped once the frame completes"
+
+// frameStart is the first node evaluated in a call of the interpreter stack.
+type frameStart struct {
+	// index of the call in the interpreter stack
+	index int
+	// frame is the *callFrame of the call. It is kept as a pointer rather
+	// than a reflect.Value, which would refer to the slot of the stack the
+	// frame is in, and so to the frames of later calls.
+	frame unsafe.Pointer
+	node  ast.Node
+}
+
+// install enables restarting frames of the evaluation followed by hooks.
+// It must be called before the evaluation starts.
+func (r *frameRestarter) install(hooks *evalHooks) error {
+	if !hooks.installed.Load() {
+		return fmt.Errorf("unsupported version of go-jsonnet: the evaluation hooks are not installed")
+	}
+	hooks.restarter = r
+	r.mu.Lock()
+	r.installed = true
+	r.mu.Unlock()
+	return nil
+}
+
+// beforeHook is called by the evaluation hooks before the hooks of the
+// debugger, which may stop the evaluation. It returns false for the hooks
+// run by the frontend while the debugger is stopped, otherwise afterHook
+// must be called after the hook of the debugger. node is nil for the post
+// hook.
+//
+// The debugger skips its hooks while a frame is evaluated again as it is
+// evaluated with Debugger.LookupValue, so they are enabled for it.
+func (r *frameRestarter) beforeHook(hooks *evalHooks, interp unsafe.Pointer, node ast.Node) bool {
+	if r.stopped.Load() {
+		return false
+	}
+	if node != nil {
+		r.track(hooks, interp, node)
+	}
+	if r.depth > 0 {
+		*hooks.skip = false
+	}
+	r.stopped.Store(true)
+	return true
+}
+
+// afterHook evaluates the frame to restart if the frontend asked for it
+// while the debugger was stopped.
+func (r *frameRestarter) afterHook(hooks *evalHooks) {
+	r.stopped.Store(false)
+	if r.depth > 0 {
+		*hooks.skip = true
+	}
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+	if pending != nil {
+		r.replay(hooks.dbg, *pending)
+	}
+}
+
+// track records the node a call starts with when it is evaluated first,
+// and forgets the calls that returned.
+func (r *frameRestarter) track(hooks *evalHooks, interp unsafe.Pointer, node ast.Node) {
+	frames := hooks.frames(interp)
+	if len(frames) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.starts) > 0 {
+		last := r.starts[len(r.starts)-1]
+		if last.index < len(frames) && frames[last.index] == last.frame {
+			break
+		}
+		r.starts = r.starts[:len(r.starts)-1]
+	}
+	top := frames[len(frames)-1]
+	if !hooks.isCall(top) {
+		return
+	}
+	if len(r.starts) > 0 && r.starts[len(r.starts)-1].frame == top {
+		return
+	}
+	r.starts = append(r.starts, frameStart{index: len(frames) - 1, frame: top, node: node})
+}
+
+// frameStart returns the start of frame, an index into stackTrace. Frames
+// of stackTrace run in the call before the one their location calls, the
+// first one has none.
+func (r *frameRestarter) frameStart(dbg *jsonnet.Debugger, frame int) (*frameStart, error) {
+	_, frames, err := callStack(dbg)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.installed {
+		return nil, fmt.Errorf("Restarting frames is not enabled")
+	}
+	calls := 0
+	for k := 0; k < frames.Len(); k++ {
+		call, err := isCall(frames.Index(k))
+		if err != nil {
+			return nil, err
+		}
+		if !call {
+			continue
+		}
+		calls++
+		if calls != frame {
+			continue
+		}
+		for _, s := range r.starts {
+			if s.index == k && s.frame == framePointer(frames, k) {
+				return &s, nil
+			}
+		}
+		break
+	}
+	return nil, fmt.Errorf("This frame cannot be restarted")
+}
+
+// canRestart reports whether frame, an index into stackTrace, can be
+// restarted.
+func (r *frameRestarter) canRestart(dbg *jsonnet.Debugger, frame int) bool {
+	_, err := r.frameStart(dbg, frame)
+	return err == nil
+}
+
+// restart resumes the evaluation to evaluate frame, an index into
+// stackTrace, again. The debugger stops at its first node.
+func (r *frameRestarter) restart(dbg *jsonnet.Debugger, frame int) error {
+	start, err := r.frameStart(dbg, frame)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.pending = start
+	r.restarting = true
+	r.mu.Unlock()
+	dbg.Continue()
+	return nil
+}
+
+// restarted reports whether a step stop is the start of a restarted frame.
+func (r *frameRestarter) restarted() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	restarted := r.restarting
+	r.restarting = false
+	return restarted
+}
+
+// replay evaluates the node start begins with again, with the calls made
+// since hidden from the stack. Debugger.LookupValue is the only way to run
+// the interpreter, so the node is bound to a variable of the call like in
+// forceValue.
+func (r *frameRestarter) replay(dbg *jsonnet.Debugger, start frameStart) {
+	defer func() {
+		r.mu.Lock()
+		r.restarting = false
+		r.mu.Unlock()
+	}()
+	stack, frames, err := callStack(dbg)
+	if err != nil || start.index >= frames.Len() || framePointer(frames, start.index) != start.frame {
+		slog.Warn("unable to restart frame", "err", err)
+		return
+	}
+	upValues, err := frameBindings(frames.Index(start.index))
+	if err != nil {
+		slog.Warn("unable to restart frame", "err", err)
+		return
+	}
+	// Evaluated through a local, so the hooks are called for the node itself
+	body := &ast.Local{NodeBase: ast.NodeBase{LocRange: *start.node.Loc()}, Body: start.node}
+	thunk := reflect.New(upValues.Type().Elem().Elem())
+	if err := setThunk(thunk, body, reflect.Value{}); err != nil {
+		slog.Warn("unable to restart frame", "err", err)
+		return
+	}
+	if upValues.IsNil() {
+		upValues.Set(reflect.MakeMap(upValues.Type()))
+		defer upValues.SetZero()
+	}
+	key := reflect.ValueOf(ast.Identifier(evalValue))
+	upValues.SetMapIndex(key, thunk)
+	defer upValues.SetMapIndex(key, reflect.Value{})
+
+	// Hide the calls made since the frame started, in a new backing array
+	// as the evaluation pushes frames. The stack is left as is if the
+	// evaluation fails, so it is restored entirely.
+	orig := reflect.New(stack.Type()).Elem()
+	orig.Set(stack)
+	defer stack.Set(orig)
+	r.mu.Lock()
+	starts := append([]frameStart{}, r.starts...)
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.starts = starts
+		r.mu.Unlock()
+	}()
+	truncated := reflect.MakeSlice(frames.Type(), start.index+1, start.index+1)
+	reflect.Copy(truncated, frames.Slice(0, start.index+1))
+	frames.Set(truncated)
+
+	if err := setSingleStep(dbg, true); err != nil {
+		slog.Warn("unable to restart frame", "err", err)
+		return
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+	if _, err := dbg.LookupValue(evalValue); err != nil {
+		slog.Info("restarted frame failed", "err", err)
+	}
+}
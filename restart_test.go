@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-dap"
+	"github.com/google/go-jsonnet"
+)
+
+func TestRestartFrame(t *testing.T) {
+	src := `local f(x) =
+  local y = x + 1;
+  y * 2;
+f(1)
+`
+	var h evalHooks
+	var r frameRestarter
+	dbg := stopWith(t, src, 3, 3, func(dbg *jsonnet.Debugger) error {
+		if err := h.install(dbg); err != nil {
+			return err
+		}
+		return r.install(&h)
+	})
+	frame := len(stackTrace(dbg)) - 1
+	if !r.canRestart(dbg, frame) {
+		t.Fatal("the frame of f cannot be restarted")
+	}
+	if err := r.restart(dbg, frame); err != nil {
+		t.Fatal(err)
+	}
+	stop := waitStop(t, dbg)
+	if stop == nil || !r.restarted() {
+		t.Fatalf("got %+v, want the restart", stop)
+	}
+	if begin := stop.Current.Loc().Begin; begin.Line != 2 || begin.Column != 3 {
+		t.Errorf("restarted at %v, want 2:3", begin)
+	}
+	if out := finish(t, dbg); out != "4\n" {
+		t.Errorf("restarting changed the output to %q", out)
+	}
+}
+
+func TestRestartFrameDisabled(t *testing.T) {
+	var h evalHooks
+	var r frameRestarter
+	dbg := stopWith(t, "local f(x) =\n  x * 2;\nf(1)\n", 2, 3, h.install)
+	if r.canRestart(dbg, len(stackTrace(dbg))-1) {
+		t.Error("frames can be restarted without enabling it")
+	}
+	if h.restarter != nil {
+		t.Error("frames are tracked without enabling restarts")
+	}
+	finish(t, dbg)
+}
+
+func TestRestartFrameCapability(t *testing.T) {
+	path := writeFile(t, "local f(x) =\n  x * 2;\nf(1)\n")
+	c := startSession(t)
+	c.send("initialize", map[string]any{"adapterID": "jsonnet"})
+	if await[*dap.InitializeResponse](c).Body.SupportsRestartFrame {
+		t.Error("restarting frames is advertised without enabling it")
+	}
+	c.setBreakpoints(path, dap.SourceBreakpoint{Line: 2})
+	c.send("configurationDone", nil)
+	await[*dap.ConfigurationDoneResponse](c)
+	c.send("launch", map[string]any{"program": path, "restartFrame": true})
+	await[*dap.LaunchResponse](c)
+	if !await[*dap.CapabilitiesEvent](c).Body.Capabilities.SupportsRestartFrame {
+		t.Error("restarting frames is not advertised once enabled")
+	}
+	await[*dap.StoppedEvent](c)
+	c.send("stackTrace", dap.StackTraceArguments{ThreadId: 1})
+	frame := await[*dap.StackTraceResponse](c).Body.StackFrames[0]
+	if !frame.CanRestart {
+		t.Fatalf("frame %s cannot be restarted", frame.Name)
+	}
+	c.send("restartFrame", dap.RestartFrameArguments{FrameId: frame.Id})
+	await[*dap.RestartFrameResponse](c)
+	stopped := await[*dap.StoppedEvent](c).Body
+	if stopped.Reason != "restart" || !strings.Contains(stopped.Text, "not rewound") {
+		t.Errorf("stopped for %s: %s, want replaying the frame", stopped.Reason, stopped.Text)
+	}
+	c.finish()
+}
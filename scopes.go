@@ -0,0 +1,125 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+)
+
+// frameVariables are the variables of an environment, grouped by where they
+// were bound.
+type frameVariables struct {
+	// arguments are the parameters of the innermost function.
+	arguments []string
+	// locals are bound by `local` (including object locals) within the
+	// innermost function, or anywhere outside functions.
+	locals []string
+	// closure are captured from outside the innermost function.
+	closure []string
+}
+
+// classifyVariables groups the variables in vars by looking up where they
+// are bound in the program, given the nodes enclosing the position of the
+// debugger, innermost first. Variables that are not found, e.g. if the
+// program could not be parsed, are treated as locals. Internal variables of
+// the desugared program, like `$std`, as well as `std` and `$` are left out.
+func classifyVariables(enclosing []ast.Node, vars []ast.Identifier) frameVariables {
+	kinds := map[string]*[]string{}
+	fv := frameVariables{}
+	bind := func(name ast.Identifier, target *[]string) {
+		if _, ok := kinds[string(name)]; !ok {
+			kinds[string(name)] = target
+		}
+	}
+	inFunction := false
+	for _, n := range enclosing {
+		scope := &fv.locals
+		if inFunction {
+			scope = &fv.closure
+		}
+		switch n := n.(type) {
+		case *ast.Local:
+			for _, b := range n.Binds {
+				bind(b.Variable, scope)
+			}
+		case *ast.DesugaredObject:
+			for _, b := range n.Locals {
+				bind(b.Variable, scope)
+			}
+		case *ast.Function:
+			if !inFunction {
+				scope = &fv.arguments
+			}
+			for _, p := range n.Parameters {
+				bind(p.Name, scope)
+			}
+			inFunction = true
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, v := range vars {
+		name := string(v)
+		if seen[name] || name == "std" || strings.HasPrefix(name, "$") {
+			continue
+		}
+		seen[name] = true
+		target, ok := kinds[name]
+		if !ok {
+			target = &fv.locals
+		}
+		*target = append(*target, name)
+	}
+	sort.Strings(fv.arguments)
+	sort.Strings(fv.locals)
+	sort.Strings(fv.closure)
+	return fv
+}
+
+// ancestors returns the nodes enclosing the node of the program at loc,
+// innermost first. If several nodes share the location, the deepest one
+// matching typ is used, typ may be nil to match any node.
+//
+// Nodes from the AST evaluated by the debugger are found again by their
+// location and type, as the program is parsed separately.
+func ancestors(program []*programFile, loc *ast.LocationRange, typ reflect.Type) []ast.Node {
+	if loc == nil || loc.File == nil {
+		return nil
+	}
+	for _, f := range program {
+		if f.path != string(loc.File.DiagnosticFileName) {
+			continue
+		}
+		path := findNode(f.node, loc.String(), typ)
+		if len(path) == 0 {
+			return nil
+		}
+		// The node itself does not bind anything yet
+		path = path[:len(path)-1]
+		for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+			path[i], path[j] = path[j], path[i]
+		}
+		return path
+	}
+	return nil
+}
+
+// findNode returns the path from node to the deepest node at loc matching
+// typ.
+func findNode(node ast.Node, loc string, typ reflect.Type) []ast.Node {
+	if node == nil {
+		return nil
+	}
+	for _, c := range toolutils.Children(node) {
+		if path := findNode(c, loc, typ); path != nil {
+			return append([]ast.Node{node}, path...)
+		}
+	}
+	if (typ == nil || reflect.TypeOf(node) == typ) && node.Loc() != nil && node.Loc().String() == loc {
+		return []ast.Node{node}
+	}
+	return nil
+}
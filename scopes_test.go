@@ -0,0 +1,151 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/go-jsonnet"
+)
+
+// dollarField returns the field name of $ as shown by the debugger.
+func dollarField(t *testing.T, dbg *jsonnet.Debugger, name string) string {
+	t.Helper()
+	v, err := evaluate(dbg, "$."+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.String()
+}
+
+func TestDollarInNestedObjects(t *testing.T) {
+	src := `{
+  name: 'app',
+  spec: {
+    containers: [{ image: std.join(':', ['nginx', 'latest']) }],
+  },
+}
+`
+	var h evalHooks
+	dbg := stopWith(t, src, 4, 27, h.install)
+	if got := dollarField(t, dbg, "name"); got != `"app"` {
+		t.Errorf("$.name = %s, want \"app\"", got)
+	}
+	v, err := lookupValue(dbg, "$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.kind != valueKindObject || v.size != 2 {
+		t.Errorf("$ = %s, want the outermost object", v)
+	}
+	finish(t, dbg)
+}
+
+func TestDollarForcedLazily(t *testing.T) {
+	// The nested fields are forced by the manifestation of the array, once
+	// the objects binding $ have been returned
+	src := `local make(n) = { root: n, nested: { value: n * 2 } };
+[make(1), make(2)]
+`
+	var h evalHooks
+	dbg := stopWith(t, src, 1, 45, h.install)
+	if got := dollarField(t, dbg, "root"); got != "1" {
+		t.Errorf("$.root = %s, want 1", got)
+	}
+	dbg.Continue()
+	if stop := waitStop(t, dbg); stop == nil {
+		t.Fatal("the program exited before evaluating the second object")
+	}
+	if got := dollarField(t, dbg, "root"); got != "2" {
+		t.Errorf("$.root = %s, want 2", got)
+	}
+	if out := finish(t, dbg); out != "[\n   {\n      \"nested\": {\n         \"value\": 2\n      },\n      \"root\": 1\n   },\n   {\n      \"nested\": {\n         \"value\": 4\n      },\n      \"root\": 2\n   }\n]\n" {
+		t.Errorf("got output %q", out)
+	}
+}
+
+func TestSuperFields(t *testing.T) {
+	src := `local base = { a: 1, b:: 2 };
+local mid = { d: 4 };
+base + mid + {
+  a: super.a + 10,
+  c: 3,
+}
+`
+	dbg := stopAt(t, src, 4, 6)
+	fields, err := superFields(dbg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"a": false, "b": true, "d": false}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("super has fields %v, want %v", fields, want)
+	}
+	v, err := evaluate(dbg, fieldExpression("super", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.String(); got != "1" {
+		t.Errorf("super.a = %s, want 1", got)
+	}
+	if out := finish(t, dbg); out != "{\n   \"a\": 11,\n   \"c\": 3,\n   \"d\": 4\n}\n" {
+		t.Errorf("got output %q", out)
+	}
+}
+
+func TestSuperFieldsOutsideObjects(t *testing.T) {
+	src := `local f(x) =
+  x + 1;
+f(1)
+`
+	dbg := stopAt(t, src, 2, 3)
+	fields, err := superFields(dbg)
+	if err != nil || len(fields) != 0 {
+		t.Errorf("super has fields %v outside of objects, error %v", fields, err)
+	}
+	finish(t, dbg)
+}
+
+func TestDollarInComprehension(t *testing.T) {
+	src := `{
+  [k]: { v: std.length(k) }
+  for k in ['ab']
+}
+`
+	var h evalHooks
+	dbg := stopWith(t, src, 2, 13, h.install)
+	v, err := lookupValue(dbg, "$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.kind != valueKindObject {
+		t.Errorf("$ = %s, want an object", v)
+	}
+	if out := finish(t, dbg); out != "{\n   \"ab\": {\n      \"v\": 2\n   }\n}\n" {
+		t.Errorf("got output %q", out)
+	}
+}
+
+func TestClassifyVariables(t *testing.T) {
+	src := `local top = 1;
+local make(a, b) =
+  local inner = a + b;
+  local g(c) =
+    local sum = inner + c;
+    sum + top;
+  g(1);
+make(2, 3)
+`
+	dbg := stopAt(t, src, 6, 5)
+	node := stopNode(t, dbg)
+	program, err := resolveProgram(string(node.Loc().File.DiagnosticFileName), src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fv := classifyVariables(ancestors(program, node.Loc(), reflect.TypeOf(node)), dbg.ListVars())
+	want := frameVariables{arguments: []string{"c"}, locals: []string{"sum"}, closure: []string{"inner", "top"}}
+	if !reflect.DeepEqual(fv, want) {
+		t.Errorf("got %+v, want %+v", fv, want)
+	}
+	if out := finish(t, dbg); out != "7\n" {
+		t.Errorf("got output %q", out)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// loadedSource is a file loaded by the program, either the main file or an
+// import.
+type loadedSource struct {
+	// path is the name the importer found the file under.
+	path string
+	// jpath is the library path the file was found in, empty if it was
+	// found relative to the importing file or by its absolute path.
+	jpath string
+}
+
+// isVirtual reports whether the source name has no file on disk, such as
+// `<std>`, `<cmdline>` or the code of external variables.
+func isVirtual(name string) bool {
+	if strings.HasPrefix(name, "<") && strings.HasSuffix(name, ">") {
+		return true
+	}
+	info, err := os.Stat(name)
+	return err != nil || info.IsDir()
+}
+
+// virtualSources assigns source references to sources without a file on
+// disk, so clients can fetch their contents with source requests.
+type virtualSources struct {
+	mu       sync.Mutex
+	refs     map[string]int
+	contents []string
+}
+
+// reference returns the source reference of name, 0 if its contents are
+// unknown. Sources parsed by the debugger are registered with the lines of
+// file, others have to be added before.
+func (v *virtualSources) reference(name string, file *ast.Source) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if ref, ok := v.refs[name]; ok {
+		return ref
+	}
+	if file == nil {
+		return 0
+	}
+	// Sources end with an extra newline, see ast.BuildSource
+	return v.addLocked(name, strings.TrimSuffix(strings.Join(file.Lines, ""), "\n"))
+}
+
+// add registers the contents of the source name.
+func (v *virtualSources) add(name, contents string) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.addLocked(name, contents)
+}
+
+func (v *virtualSources) addLocked(name, contents string) int {
+	if v.refs == nil {
+		v.refs = map[string]int{}
+	}
+	if ref, ok := v.refs[name]; ok {
+		v.contents[ref-1] = contents
+		return ref
+	}
+	v.contents = append(v.contents, contents)
+	v.refs[name] = len(v.contents)
+	return len(v.contents)
+}
+
+func (v *virtualSources) get(ref int) (string, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if ref < 1 || ref > len(v.contents) {
+		return "", false
+	}
+	return v.contents[ref-1], true
+}
+
+// loadedSources records the files loaded by the program, in the order they
+// were loaded.
+type loadedSources struct {
+	mu      sync.Mutex
+	sources []loadedSource
+	seen    map[string]bool
+}
+
+// add records s, and reports whether it was loaded for the first time.
+func (l *loadedSources) add(s loadedSource) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seen == nil {
+		l.seen = map[string]bool{}
+	}
+	if l.seen[s.path] {
+		return false
+	}
+	l.seen[s.path] = true
+	l.sources = append(l.sources, s)
+	return true
+}
+
+// find returns the name file was loaded as, comparing absolute paths.
+func (l *loadedSources) find(file string) (string, bool) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range l.sources {
+		if full, err := filepath.Abs(s.path); err == nil && full == abs {
+			return s.path, true
+		}
+	}
+	return "", false
+}
+
+func (l *loadedSources) list() []loadedSource {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]loadedSource{}, l.sources...)
+}
+
+// trackingImporter is a FileImporter calling onLoad for every file it
+// loads, including the library path the file was found in.
+type trackingImporter struct {
+	jsonnet.FileImporter
+	onLoad func(loadedSource)
+}
+
+func (i *trackingImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	contents, foundAt, err := i.FileImporter.Import(importedFrom, importedPath)
+	if err != nil {
+		return contents, foundAt, err
+	}
+	s := loadedSource{path: foundAt}
+	// Search the library paths in the same order as FileImporter, if the
+	// file was not found relative to the importing one
+	dir, _ := filepath.Split(importedFrom)
+	if !filepath.IsAbs(importedPath) && foundAt != filepath.Join(dir, importedPath) {
+		for j := len(i.JPaths) - 1; j >= 0; j-- {
+			if foundAt == filepath.Join(i.JPaths[j], importedPath) {
+				s.jpath = i.JPaths[j]
+				break
+			}
+		}
+	}
+	i.onLoad(s)
+	return contents, foundAt, nil
+}
+
+// launchTracked starts the evaluation like Debugger.Launch, with jpaths as
+// library paths, and calls onLoad for the program and every file it loads.
+func launchTracked(dbg *jsonnet.Debugger, filename, snippet string, jpaths []string, onLoad func(loadedSource)) {
+	onLoad(loadedSource{path: filename})
+	importer := &trackingImporter{
+		FileImporter: jsonnet.FileImporter{JPaths: jpaths},
+		onLoad:       onLoad,
+	}
+	if err := launch(dbg, filename, snippet, importer); err != nil {
+		slog.Warn("unable to track imports", "err", err)
+		dbg.Launch(filename, snippet, jpaths)
+	}
+}
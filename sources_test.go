@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-dap"
+)
+
+func TestLoadedSources(t *testing.T) {
+	lib := t.TempDir()
+	if err := os.WriteFile(filepath.Join(lib, "lib.libsonnet"), []byte("{ name: 'app' }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := writeFile(t, "local lib = import 'lib.libsonnet';\nlocal again = import 'lib.libsonnet';\n[lib.name, again.name]\n")
+	c := launchWith(t, map[string]any{"program": path, "jpaths": []string{lib}}, nil)
+	var events []string
+	for range 2 {
+		e := await[*dap.LoadedSourceEvent](c).Body
+		events = append(events, e.Reason+" "+filepath.Base(e.Source.Path)+" "+e.Source.Origin)
+	}
+	want := []string{"new main.jsonnet ", "new lib.libsonnet jpath " + lib}
+	if !slices.Equal(events, want) {
+		t.Errorf("got events %q, want %q", events, want)
+	}
+	c.send("loadedSources", nil)
+	var paths []string
+	for _, s := range await[*dap.LoadedSourcesResponse](c).Body.Sources {
+		paths = append(paths, s.Path)
+	}
+	// Importing a file again does not load it again
+	if want := []string{path, filepath.Join(lib, "lib.libsonnet")}; !slices.Equal(paths, want) {
+		t.Errorf("got loaded sources %q, want %q", paths, want)
+	}
+	await[*dap.TerminatedEvent](c)
+}
+
+func TestVirtualSources(t *testing.T) {
+	code := "local check(x) = std.assertEqual(x, 2);\ncheck(1)\n"
+	c := launchWith(t, map[string]any{"code": code}, nil)
+	if stopped := await[*dap.StoppedEvent](c); stopped.Body.Reason != "exception" {
+		t.Fatalf("stopped for %s, want the failed assertion", stopped.Body.Reason)
+	}
+	c.send("stackTrace", dap.StackTraceArguments{ThreadId: 1})
+	sources := map[string]*dap.Source{}
+	for _, frame := range await[*dap.StackTraceResponse](c).Body.StackFrames {
+		if frame.Source != nil {
+			sources[frame.Source.Name] = frame.Source
+		}
+	}
+	for name, want := range map[string]string{"<cmdline>": code, "<std>": "assertEqual(a, b)::"} {
+		src := sources[name]
+		if src == nil || src.SourceReference == 0 || src.Path != "" {
+			t.Errorf("got source %+v for %s, want a reference to its contents", src, name)
+			continue
+		}
+		c.send("source", dap.SourceArguments{Source: src, SourceReference: src.SourceReference})
+		if got := await[*dap.SourceResponse](c).Body.Content; !strings.Contains(got, want) {
+			t.Errorf("%s: got contents %.80q, want them to contain %q", name, got, want)
+		}
+	}
+	if sources["<std>"] != nil && sources["<std>"].Origin != "standard library" {
+		t.Errorf("the standard library has origin %q", sources["<std>"].Origin)
+	}
+	c.finish()
+}
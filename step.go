@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+)
+
+// stepper implements stepping that spans several debugger steps. The
+// debugger can only stop at the next node, so the evaluation hooks follow
+// the frame being stepped out of until it returns, while stepping into a
+// target steps until it is reached.
+type stepper struct {
+	mu sync.Mutex
+	// hooks follow the frame stepped out of.
+	hooks *evalHooks
+	// out is set while stepping out or into.
+	out bool
+	// into is the node being stepped into. Stepping stops there, or when the
+	// frame it was stepped into from returns without entering it.
+	into ast.Node
+	// targets are the step in targets of the node stopped at, their ids are
+	// their indexes plus one.
+	targets []ast.Node
+	// returned is set when the debugger stopped right after the frame
+	// stepped out of returned.
+	returned bool
+}
+
+// stepOut runs until the current stack frame returns, and stops right
+// after it.
+func (s *stepper) stepOut(dbg *jsonnet.Debugger, hooks *evalHooks) error {
+	s.mu.Lock()
+	if err := hooks.watchFrame(dbg, true); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.hooks = hooks
+	s.out = true
+	s.into = nil
+	s.returned = false
+	s.mu.Unlock()
+	dbg.Continue()
+	return nil
+}
+
+// stepInTargets lists the sub-expressions of node that can be stepped into
+// and returns them for display.
+func (s *stepper) stepInTargets(node ast.Node) []ast.Node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targets = stepInTargets(node)
+	return s.targets
+}
+
+// stepInto runs until the step in target id is entered.
+func (s *stepper) stepInto(dbg *jsonnet.Debugger, hooks *evalHooks, id int) error {
+	s.mu.Lock()
+	if id < 1 || id > len(s.targets) {
+		s.mu.Unlock()
+		return fmt.Errorf("Unknown step in target %d", id)
+	}
+	if err := hooks.watchFrame(dbg, false); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.hooks = hooks
+	s.into = s.targets[id-1]
+	s.out = true
+	s.returned = false
+	s.mu.Unlock()
+	dbg.Step()
+	return nil
+}
+
+// stepping reports whether a step stop is part of a step out or step into.
+func (s *stepper) stepping() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.out
+}
+
+// done reports whether the step out or step into finished at ev.
+func (s *stepper) done(ev *jsonnet.DebugEventStop) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.into != nil && ev.Current == s.into {
+		s.out = false
+		s.into = nil
+		s.hooks.unwatch()
+		return true
+	}
+	if s.hooks.returned.Load() == nil {
+		return false
+	}
+	s.out = false
+	s.into = nil
+	s.returned = true
+	return true
+}
+
+// reset cancels stepping and forgets the returned value, it must be called
+// whenever the evaluation resumes or stops for another reason.
+func (s *stepper) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hooks != nil {
+		s.hooks.unwatch()
+	}
+	s.out = false
+	s.into = nil
+	s.targets = nil
+	s.returned = false
+}
+
+// returnValue returns the value of the frame that was stepped out of, if
+// the debugger is stopped right after stepping out.
+func (s *stepper) returnValue() (*debugValue, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.returned {
+		return nil, false
+	}
+	v := s.hooks.returnValue()
+	if !v.IsValid() {
+		return nil, false
+	}
+	inspected, err := inspectValue(v, previewDepth)
+	if err != nil {
+		return nil, false
+	}
+	return inspected, true
+}
+
+// stepInTargets returns the calls, indexes and field accesses evaluated as
+// part of node, in source order, including the ones in the functions it
+// defines. The function a call applies is left out, as it is evaluated
+// right after entering the call. Desugaring generates nodes with the
+// location of the expression they replace, only the outermost is kept.
+func stepInTargets(node ast.Node) []ast.Node {
+	targets := []ast.Node{}
+	seen := map[ast.LocationRange]bool{*node.Loc(): true}
+	var visit func(ast.Node)
+	visit = func(n ast.Node) {
+		if n == nil {
+			return
+		}
+		switch n := n.(type) {
+		case *ast.Apply:
+			seen[*n.Target.Loc()] = true
+		case *ast.Index:
+		default:
+			for _, c := range toolutils.Children(n) {
+				visit(c)
+			}
+			return
+		}
+		if loc := n.Loc(); loc.File != nil && loc.File.DiagnosticFileName != "<std>" && !seen[*loc] {
+			seen[*loc] = true
+			targets = append(targets, n)
+		}
+		for _, c := range toolutils.Children(n) {
+			visit(c)
+		}
+	}
+	for _, c := range toolutils.Children(node) {
+		visit(c)
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		a, b := targets[i].Loc().Begin, targets[j].Loc().Begin
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return targets
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// stepUntilDone keeps stepping like the frontends until s finished, and
+// returns the stop it finished at.
+func stepUntilDone(t *testing.T, dbg *jsonnet.Debugger, s *stepper) *jsonnet.DebugEventStop {
+	t.Helper()
+	for {
+		stop := waitStop(t, dbg)
+		if stop == nil {
+			t.Fatal("the program exited while stepping")
+		}
+		if !s.stepping() || s.done(stop) {
+			return stop
+		}
+		dbg.Step()
+	}
+}
+
+func TestStepOut(t *testing.T) {
+	src := `local f(x) =
+  local y = x + 1;
+  y * 2;
+local a = f(1);
+[a, a + 10]
+`
+	for _, tc := range []struct {
+		name         string
+		line, column int
+		want         string
+	}{
+		{"function", 3, 3, "4"},
+		// y is evaluated lazily, from y * 2
+		{"thunk", 2, 13, "2"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var h evalHooks
+			var s stepper
+			dbg := stopWith(t, src, tc.line, tc.column, h.install)
+			if err := s.stepOut(dbg, &h); err != nil {
+				t.Fatal(err)
+			}
+			stop := stepUntilDone(t, dbg, &s)
+			if stop.Reason != jsonnet.StopReasonStep {
+				t.Errorf("stopped for %v, want a step", stop.Reason)
+			}
+			returned, ok := s.returnValue()
+			if !ok {
+				t.Fatal("no return value")
+			}
+			if got := returned.String(); got != tc.want {
+				t.Errorf("returned %s, want %s", got, tc.want)
+			}
+			s.reset()
+			if _, ok := s.returnValue(); ok {
+				t.Error("the return value is kept after resuming")
+			}
+			if out := finish(t, dbg); out != "[\n   4,\n   14\n]\n" {
+				t.Errorf("got output %q", out)
+			}
+		})
+	}
+}
+
+func TestStepInto(t *testing.T) {
+	src := `local f(x) = x * 2;
+local g(x) = x + 1;
+f(1) + g(2)
+`
+	var h evalHooks
+	var s stepper
+	dbg := stopWith(t, src, 3, 1, h.install)
+	targets := s.stepInTargets(stopNode(t, dbg))
+	id := 0
+	for i, target := range targets {
+		if begin := target.Loc().Begin; begin.Line == 3 && begin.Column == 8 {
+			id = i + 1
+		}
+	}
+	if id == 0 {
+		t.Fatalf("g(2) is not a step in target of %d targets", len(targets))
+	}
+	if err := s.stepInto(dbg, &h, id); err != nil {
+		t.Fatal(err)
+	}
+	stop := stepUntilDone(t, dbg, &s)
+	if begin := stop.Current.Loc().Begin; begin.Line != 3 || begin.Column != 8 {
+		t.Errorf("stopped at %v, want 3:8", begin)
+	}
+	s.reset()
+	if out := finish(t, dbg); out != "5\n" {
+		t.Errorf("got output %q", out)
+	}
+}
+
+// stopNode returns the node dbg is stopped at.
+func stopNode(t *testing.T, dbg *jsonnet.Debugger) ast.Node {
+	t.Helper()
+	current, err := debuggerField(dbg, "current", reflect.Interface)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, ok := current.Interface().(ast.Node)
+	if !ok {
+		t.Fatal("the debugger is not stopped at a node")
+	}
+	return node
+}
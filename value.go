@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type valueKind int
+
+const (
+	valueKindUnknown valueKind = iota
+	valueKindNull
+	valueKindBoolean
+	valueKindNumber
+	valueKindString
+	valueKindArray
+	valueKindObject
+	valueKindFunction
+)
+
+func (k valueKind) String() string {
+	switch k {
+	case valueKindNull:
+		return "null"
+	case valueKindBoolean:
+		return "boolean"
+	case valueKindNumber:
+		return "number"
+	case valueKindString:
+		return "string"
+	case valueKindArray:
+		return "array"
+	case valueKindObject:
+		return "object"
+	case valueKindFunction:
+		return "function"
+	}
+	return "unknown"
+}
+
+// debugValue is the structured form of a value of the interpreter, see
+// inspectValue. Objects only contain the fields that have already been
+// evaluated, and array elements that have not been forced yet are of kind
+// valueKindUnknown.
+type debugValue struct {
+	kind valueKind
+	// scalar holds the contents of strings, the formatted number, "true"
+	// or "false" for booleans, the parameter list of functions and the Go
+	// type of values that cannot be inspected.
+	scalar   string
+	fields   []debugField
+	elements []*debugValue
+	// runtime is the value of the interpreter, invalid for values that are
+	// not evaluated or do not come from the interpreter.
+	runtime reflect.Value
+	// size is the number of fields or elements of objects and arrays, and
+	// truncated is set if they were not inspected because of the depth.
+	size      int
+	truncated bool
+}
+
+type debugField struct {
+	name   string
+	hidden bool
+	value  *debugValue
+}
+
+// String renders the value in a compact, Jsonnet-like notation.
+func (v *debugValue) String() string {
+	switch v.kind {
+	case valueKindNull:
+		return "null"
+	case valueKindBoolean, valueKindNumber:
+		return v.scalar
+	case valueKindString:
+		return quote(v.scalar)
+	case valueKindFunction:
+		return "function(" + v.scalar + ")"
+	case valueKindArray:
+		if v.truncated && v.size > 0 {
+			return "[…]"
+		}
+		parts := make([]string, len(v.elements))
+		for i, e := range v.elements {
+			parts[i] = e.String()
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case valueKindObject:
+		if v.truncated && v.size > 0 {
+			return "{…}"
+		}
+		parts := make([]string, len(v.fields))
+		for i, f := range v.sortedFields() {
+			sep := ": "
+			if f.hidden {
+				sep = ":: "
+			}
+			parts[i] = f.name + sep + f.value.String()
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	}
+	if v.scalar != "" {
+		return "<" + v.scalar + ">"
+	}
+	return "<not evaluated>"
+}
+
+func (v *debugValue) sortedFields() []debugField {
+	fields := append([]debugField{}, v.fields...)
+	sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	return fields
+}
+
+// formatNumber formats numbers like std.toString, without the exponent of
+// %g for integers.
+func formatNumber(f float64) string {
+	if f == math.Trunc(f) && math.Abs(f) < 1e21 {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// quote returns s as a double quoted Jsonnet (and JSON) string literal.
+func quote(s string) string {
+	var sb strings.Builder
+	enc := json.NewEncoder(&sb)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return strconv.Quote(s)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
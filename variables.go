@@ -0,0 +1,291 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/google/go-dap"
+	"github.com/google/go-jsonnet"
+)
+
+// variableHandles hands out the variablesReference ids DAP clients use to
+// expand scopes and structured values. They are only valid while the
+// debugger is stopped.
+type variableHandles struct {
+	mu      sync.Mutex
+	handles []any
+}
+
+// create returns a new reference to v, references start at 1 as 0 means the
+// variable cannot be expanded.
+func (h *variableHandles) create(v any) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handles = append(h.handles, v)
+	return len(h.handles)
+}
+
+func (h *variableHandles) get(ref int) (any, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ref < 1 || ref > len(h.handles) {
+		return nil, false
+	}
+	return h.handles[ref-1], true
+}
+
+// reset invalidates all references.
+func (h *variableHandles) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handles = nil
+}
+
+// scopeVariables is the reference to a scope listing variables of the
+// current environment.
+type scopeVariables struct {
+	names []string
+	// frame is the index of the stack frame the variables are from.
+	frame int
+	// returnValue includes the value returned by the frame stepped out of.
+	returnValue bool
+}
+
+// superScope is the reference to the fields super gives access to in the
+// environment of the stack frame frame, with whether they are hidden.
+type superScope struct {
+	fields map[string]bool
+	frame  int
+}
+
+// externalScope is the reference to the external variables and top-level
+// arguments of the program.
+type externalScope struct{}
+
+// valueContainer is the reference to the fields or elements of a value,
+// which are evaluated when the client expands it.
+type valueContainer struct {
+	value *debugValue
+	// evaluateName is the expression evaluating to value, in the
+	// environment of the stack frame frame.
+	evaluateName string
+	frame        int
+	// cached containers only show the fields and elements evaluated
+	// already, the others are not evaluated when expanding them.
+	cached bool
+}
+
+// maxValuePreview is the length after which values are cut off when shown
+// next to their name. Their contents can still be expanded.
+const maxValuePreview = 200
+
+// previewDepth is how deep values are inspected for showing them, deeper
+// objects and arrays are only shown when they are expanded.
+const previewDepth = 3
+
+// variable builds the DAP variable for v, with a reference to expand it if
+// it is an array or object. evaluateName is relative to the stack frame
+// frame.
+func (h *variableHandles) variable(frame int, name, evaluateName string, v *debugValue) dap.Variable {
+	return h.containerVariable(name, &valueContainer{value: v, evaluateName: evaluateName, frame: frame})
+}
+
+// cachedVariable is variable for values that must not be evaluated any
+// further when expanded, such as hover results.
+func (h *variableHandles) cachedVariable(frame int, name, evaluateName string, v *debugValue) dap.Variable {
+	return h.containerVariable(name, &valueContainer{value: v, evaluateName: evaluateName, frame: frame, cached: true})
+}
+
+// containerVariable builds the DAP variable for the value of c, with a
+// reference to c if it is an array or object.
+func (h *variableHandles) containerVariable(name string, c *valueContainer) dap.Variable {
+	v := c.value
+	variable := dap.Variable{
+		Name:         name,
+		Value:        valuePreview(v),
+		Type:         v.kind.String(),
+		EvaluateName: c.evaluateName,
+	}
+	if !v.runtime.IsValid() || v.size == 0 {
+		return variable
+	}
+	switch v.kind {
+	case valueKindObject:
+		variable.VariablesReference = h.create(c)
+		variable.NamedVariables = v.size
+	case valueKindArray:
+		variable.VariablesReference = h.create(c)
+		variable.IndexedVariables = v.size
+	}
+	return variable
+}
+
+// valuePreview formats v, cut off after maxValuePreview characters.
+func valuePreview(v *debugValue) string {
+	preview := v.String()
+	if r := []rune(preview); len(r) > maxValuePreview {
+		preview = string(r[:maxValuePreview]) + "…"
+	}
+	return preview
+}
+
+// children returns the fields or elements of c selected by the paging
+// arguments of a variables request. Only the selected ones are evaluated,
+// hidden fields included, and errors are shown in place of their value.
+func (h *variableHandles) children(dbg *jsonnet.Debugger, c *valueContainer, args dap.VariablesArguments) ([]dap.Variable, error) {
+	out := []dap.Variable{}
+	switch c.value.kind {
+	case valueKindObject:
+		if args.Filter == "indexed" {
+			return out, nil
+		}
+		hidden, err := objectFields(c.value.runtime)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range page(fieldNames(hidden), args.Start, args.Count) {
+			variable := h.child(dbg, c, name, quote(name), fieldExpression(c.evaluateName, name))
+			if hidden[name] {
+				variable.PresentationHint = &dap.VariablePresentationHint{Visibility: "internal"}
+			}
+			out = append(out, variable)
+		}
+	case valueKindArray:
+		if args.Filter == "named" {
+			return out, nil
+		}
+		start := min(max(args.Start, 0), c.value.size)
+		end := c.value.size
+		if args.Count > 0 {
+			end = min(start+args.Count, end)
+		}
+		for i := start; i < end; i++ {
+			index := "[" + strconv.Itoa(i) + "]"
+			evaluateName := ""
+			if c.evaluateName != "" {
+				evaluateName = indexable(c.evaluateName) + index
+			}
+			out = append(out, h.child(dbg, c, index, strconv.Itoa(i), evaluateName))
+		}
+	}
+	return out, nil
+}
+
+// child evaluates the field or element index of c for showing it as the
+// variable name.
+func (h *variableHandles) child(dbg *jsonnet.Debugger, c *valueContainer, name, index, evaluateName string) dap.Variable {
+	if c.cached {
+		return h.cachedChild(c, name, index, evaluateName)
+	}
+	v, err := indexValue(dbg, c.value.runtime, index)
+	if err == nil {
+		var inspected *debugValue
+		if inspected, err = inspectValue(v, previewDepth); err == nil {
+			return h.variable(c.frame, name, evaluateName, inspected)
+		}
+	}
+	return dap.Variable{Name: name, Value: err.Error(), Type: "error", EvaluateName: evaluateName}
+}
+
+// cachedChild is child for cached containers, it shows the field or
+// element as not evaluated instead of evaluating it.
+func (h *variableHandles) cachedChild(c *valueContainer, name, index, evaluateName string) dap.Variable {
+	var v reflect.Value
+	var err error
+	if c.value.kind == valueKindObject {
+		v, err = objectField(c.value.runtime, name)
+	} else {
+		i, _ := strconv.Atoi(index)
+		v, err = arrayElement(c.value.runtime, i)
+	}
+	var inspected *debugValue
+	if err == nil {
+		inspected, err = inspectValue(v, previewDepth)
+	}
+	if err != nil {
+		return dap.Variable{Name: name, Value: err.Error(), Type: "error", EvaluateName: evaluateName}
+	}
+	return h.cachedVariable(c.frame, name, evaluateName, inspected)
+}
+
+// superChildren returns the fields of s selected by the paging arguments of
+// a variables request, evaluated as `super.name` in the environment of the
+// stack frame of s.
+func (h *variableHandles) superChildren(dbg *jsonnet.Debugger, s *superScope, args dap.VariablesArguments) []dap.Variable {
+	out := []dap.Variable{}
+	if args.Filter == "indexed" {
+		return out
+	}
+	for _, name := range page(fieldNames(s.fields), args.Start, args.Count) {
+		evaluateName := fieldExpression("super", name)
+		v, err := evaluate(dbg, evaluateName)
+		variable := dap.Variable{Name: name, Type: "error", EvaluateName: evaluateName}
+		if err == nil {
+			variable = h.variable(s.frame, name, evaluateName, v)
+		} else {
+			variable.Value = err.Error()
+		}
+		if s.fields[name] {
+			variable.PresentationHint = &dap.VariablePresentationHint{Visibility: "internal"}
+		}
+		out = append(out, variable)
+	}
+	return out
+}
+
+// fieldNames returns the names of fields, sorted.
+func fieldNames(fields map[string]bool) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// page returns count items of s starting at start, or all of them from
+// start if count is 0.
+func page[T any](s []T, start, count int) []T {
+	start = min(max(start, 0), len(s))
+	end := len(s)
+	if count > 0 {
+		end = min(start+count, end)
+	}
+	return s[start:end]
+}
+
+var identifierPattern = regexp.MustCompile(`^[_a-zA-Z][_a-zA-Z0-9]*$`)
+
+var keywords = map[string]bool{
+	"assert": true, "else": true, "error": true, "false": true, "for": true,
+	"function": true, "if": true, "import": true, "importstr": true,
+	"importbin": true, "in": true, "local": true, "null": true,
+	"tailstrict": true, "then": true, "self": true, "super": true, "true": true,
+}
+
+// pathPattern matches expressions that can be indexed without parentheses,
+// such as `self.spec["app.kubernetes.io/name"].items[0]`.
+var pathPattern = regexp.MustCompile(`^[_a-zA-Z$][_a-zA-Z0-9]*(\.[_a-zA-Z][_a-zA-Z0-9]*|\[("([^"\\]|\\.)*"|[0-9]+)\])*$`)
+
+// indexable wraps expr in parentheses if it cannot be indexed as is.
+func indexable(expr string) string {
+	if pathPattern.MatchString(expr) {
+		return expr
+	}
+	return "(" + expr + ")"
+}
+
+// fieldExpression returns the expression accessing field of the object
+// evaluated by parent.
+func fieldExpression(parent, field string) string {
+	if parent == "" {
+		return ""
+	}
+	if identifierPattern.MatchString(field) && !keywords[field] {
+		return indexable(parent) + "." + field
+	}
+	return indexable(parent) + "[" + quote(field) + "]"
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-dap"
+	"github.com/google/go-jsonnet"
+)
+
+func TestVariableChildren(t *testing.T) {
+	src := `local f(obj) =
+  std.length(obj.items);
+f({ items: [{ name: 'a' }, error 'boom'], hidden:: self.items[0].name, count: 2 })
+`
+	dbg := stopAt(t, src, 2, 3)
+	h := &variableHandles{}
+	obj, err := lookupValue(dbg, "obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := h.variable(0, "obj", "obj", obj)
+	if v.Value != "{count: <not evaluated>, hidden:: <not evaluated>, items: <not evaluated>}" || v.NamedVariables != 3 || v.VariablesReference == 0 {
+		t.Fatalf("unexpected variable %+v", v)
+	}
+	children := expand(t, dbg, h, v.VariablesReference)
+	want := []dap.Variable{
+		{Name: "count", Value: "2", Type: "number", EvaluateName: "obj.count"},
+		{Name: "hidden", Value: `"a"`, Type: "string", EvaluateName: "obj.hidden", PresentationHint: &dap.VariablePresentationHint{Visibility: "internal"}},
+		{Name: "items", Value: "[{name: \"a\"}, <not evaluated>]", Type: "array", EvaluateName: "obj.items", IndexedVariables: 2},
+	}
+	if len(children) != len(want) {
+		t.Fatalf("got %d children, want %d", len(children), len(want))
+	}
+	for i, c := range children {
+		want[i].VariablesReference = c.VariablesReference
+		if c.Name != want[i].Name || c.Value != want[i].Value || c.Type != want[i].Type || c.EvaluateName != want[i].EvaluateName ||
+			c.IndexedVariables != want[i].IndexedVariables || (c.PresentationHint == nil) != (want[i].PresentationHint == nil) {
+			t.Errorf("child %d = %+v, want %+v", i, c, want[i])
+		}
+	}
+
+	elements := expand(t, dbg, h, children[2].VariablesReference)
+	if len(elements) != 2 {
+		t.Fatalf("got %d elements, want 2", len(elements))
+	}
+	if e := elements[0]; e.Name != "[0]" || e.Value != `{name: "a"}` || e.EvaluateName != "obj.items[0]" || e.NamedVariables != 1 {
+		t.Errorf("unexpected element %+v", e)
+	}
+	if e := elements[1]; e.Name != "[1]" || e.Value != "boom" || e.Type != "error" {
+		t.Errorf("unexpected element %+v", e)
+	}
+	if out := finish(t, dbg); out != "2\n" {
+		t.Errorf("expanding variables changed the output to %q", out)
+	}
+}
+
+// expand returns the children of the variables reference ref.
+func expand(t *testing.T, dbg *jsonnet.Debugger, h *variableHandles, ref int) []dap.Variable {
+	t.Helper()
+	handle, ok := h.get(ref)
+	if !ok {
+		t.Fatalf("invalid reference %d", ref)
+	}
+	c, ok := handle.(*valueContainer)
+	if !ok {
+		t.Fatalf("reference %d is a %T", ref, handle)
+	}
+	out, err := h.children(dbg, c, dap.VariablesArguments{VariablesReference: ref})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}